@@ -0,0 +1,214 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoadMode is how the users of the benchmark are started.
+type LoadMode string
+
+const (
+	// LoadModeClosed runs a fixed number of workers, each starting a new user when the previous one finishes.
+	// A slow application receives less load.
+	LoadModeClosed LoadMode = "closed"
+	// LoadModeOpen starts users at a target rate regardless of how fast the application serves the previous ones.
+	LoadModeOpen LoadMode = "open"
+)
+
+// ParseLoadMode returns the load mode named s. An empty name is the closed mode.
+func ParseLoadMode(s string) (LoadMode, error) {
+	switch LoadMode(s) {
+	case "", LoadModeClosed:
+		return LoadModeClosed, nil
+	case LoadModeOpen:
+		return LoadModeOpen, nil
+	default:
+		return "", fmt.Errorf("unknown load mode: %s (must be one of closed, open)", s)
+	}
+}
+
+// Interval to check the arrival rate again while it is 0
+const idleArrivalInterval = 100 * time.Millisecond
+
+// ArrivalStats summarizes the users of the open load mode.
+type ArrivalStats struct {
+	Arrivals int64
+	Started  int64
+	// Abandoned is the number of arrivals which waited longer than the patience and never started
+	Abandoned int64
+	// PeakOutstanding is the most arrivals waiting to start at the same time
+	PeakOutstanding int64
+	// StartDelay is the time from the arrival to the start of the user
+	StartDelay LatencyStats
+}
+
+// arrivalGenerator starts users at the arrival rate of the reached phases.
+// Arrivals wait for a free slot when MaxConcurrentUsers users are running, so the queue grows while the application is slow.
+type arrivalGenerator struct {
+	profile      ArrivalProfile
+	ticketRates  []float64 // rate added per ticket phase index
+	salesRates   []float64 // rate added per sales phase index
+	ticketPhase  atomic.Int32
+	salesPhase   atomic.Int32
+	slots        chan struct{} // nil if the concurrency is unlimited
+	arrivals     atomic.Int64
+	started      atomic.Int64
+	abandoned    atomic.Int64
+	active       atomic.Int64
+	outstanding  atomic.Int64
+	peak         atomic.Int64
+	mu           sync.Mutex
+	startDelays  latencyHistogram
+	runningUsers sync.WaitGroup
+}
+
+func newArrivalGenerator(profile *Profile) *arrivalGenerator {
+	g := &arrivalGenerator{
+		profile:     profile.Arrivals,
+		ticketRates: profile.TicketPhases.arrivalRates(),
+		salesRates:  profile.SalesPhases.arrivalRates(),
+	}
+	if profile.Arrivals.MaxConcurrentUsers > 0 {
+		g.slots = make(chan struct{}, profile.Arrivals.MaxConcurrentUsers)
+	}
+	return g
+}
+
+// setPhases raises the arrival rate to the one of the reached phases.
+func (g *arrivalGenerator) setPhases(ticketPhase, salesPhase int32) {
+	for {
+		current := g.ticketPhase.Load()
+		if ticketPhase <= current || g.ticketPhase.CompareAndSwap(current, ticketPhase) {
+			break
+		}
+	}
+	for {
+		current := g.salesPhase.Load()
+		if salesPhase <= current || g.salesPhase.CompareAndSwap(current, salesPhase) {
+			break
+		}
+	}
+}
+
+// rate returns the current arrival rate in users per second.
+func (g *arrivalGenerator) rate() float64 {
+	var rate float64
+	for i := 0; i <= int(g.ticketPhase.Load()) && i < len(g.ticketRates); i++ {
+		rate += g.ticketRates[i]
+	}
+	for i := 0; i <= int(g.salesPhase.Load()) && i < len(g.salesRates); i++ {
+		rate += g.salesRates[i]
+	}
+	return rate
+}
+
+// run starts runUser for each arrival until ctx is done, and returns when all the started users have finished.
+// The arrivals are a Poisson process, so the gaps between them are exponentially distributed.
+func (g *arrivalGenerator) run(ctx context.Context, rng *rand.Rand, runUser func(context.Context, *rand.Rand)) {
+	defer g.runningUsers.Wait()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+	for {
+		wait := idleArrivalInterval
+		rate := g.rate()
+		if rate > 0 {
+			wait = time.Duration(rng.ExpFloat64() / rate * float64(time.Second))
+		}
+		timer.Reset(wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		if rate > 0 {
+			g.arrive(ctx, rand.New(rand.NewSource(rng.Int63())), runUser)
+		}
+	}
+}
+
+// arrive queues a user which starts once a slot is free, or gives up after the patience.
+func (g *arrivalGenerator) arrive(ctx context.Context, rng *rand.Rand, runUser func(context.Context, *rand.Rand)) {
+	arrivedAt := time.Now()
+	g.arrivals.Add(1)
+	outstanding := g.outstanding.Add(1)
+	for {
+		peak := g.peak.Load()
+		if outstanding <= peak || g.peak.CompareAndSwap(peak, outstanding) {
+			break
+		}
+	}
+
+	g.runningUsers.Add(1)
+	go func() {
+		defer g.runningUsers.Done()
+		if !g.acquireSlot(ctx) {
+			g.outstanding.Add(-1)
+			if ctx.Err() == nil {
+				g.abandoned.Add(1)
+			}
+			return
+		}
+		defer g.releaseSlot()
+		g.outstanding.Add(-1)
+
+		g.started.Add(1)
+		g.mu.Lock()
+		g.startDelays.observe(time.Since(arrivedAt))
+		g.mu.Unlock()
+
+		g.active.Add(1)
+		defer g.active.Add(-1)
+		runUser(ctx, rng)
+	}()
+}
+
+// acquireSlot waits for a user to finish if MaxConcurrentUsers users are running.
+// It returns false if the arrival ran out of patience or the load finished first.
+func (g *arrivalGenerator) acquireSlot(ctx context.Context) bool {
+	if g.slots == nil {
+		return ctx.Err() == nil
+	}
+	var patience <-chan time.Time
+	if g.profile.Patience > 0 {
+		timer := time.NewTimer(g.profile.Patience)
+		defer timer.Stop()
+		patience = timer.C
+	}
+	select {
+	case g.slots <- struct{}{}:
+		if ctx.Err() != nil {
+			g.releaseSlot()
+			return false
+		}
+		return true
+	case <-patience:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (g *arrivalGenerator) releaseSlot() {
+	if g.slots != nil {
+		<-g.slots
+	}
+}
+
+func (g *arrivalGenerator) stats() ArrivalStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return ArrivalStats{
+		Arrivals:        g.arrivals.Load(),
+		Started:         g.started.Load(),
+		Abandoned:       g.abandoned.Load(),
+		PeakOutstanding: g.peak.Load(),
+		StartDelay:      g.startDelays.stats(),
+	}
+}
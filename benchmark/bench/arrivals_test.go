@@ -0,0 +1,75 @@
+package bench
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseLoadMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    LoadMode
+		wantErr bool
+	}{
+		{"", LoadModeClosed, false},
+		{"closed", LoadModeClosed, false},
+		{"open", LoadModeOpen, false},
+		{"half-open", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLoadMode(tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%q: expected %q (error %t), got %q, %v", tt.name, tt.want, tt.wantErr, got, err)
+		}
+	}
+}
+
+func TestArrivalRate(t *testing.T) {
+	profile := &Profile{
+		TicketPhases: PhaseProfile{InitialArrivalRate: 1, Phases: []RegistrationPhase{{Threshold: 5, ArrivalRate: 2}}},
+		SalesPhases:  PhaseProfile{InitialArrivalRate: 0.5, Phases: []RegistrationPhase{{Threshold: 1000, ArrivalRate: 4}}},
+	}
+	g := newArrivalGenerator(profile)
+	if got := g.rate(); got != 1.5 {
+		t.Errorf("expected the initial rate 1.5, got %v", got)
+	}
+	g.setPhases(1, 0)
+	if got := g.rate(); got != 3.5 {
+		t.Errorf("expected 3.5 after the first ticket phase, got %v", got)
+	}
+	// Phases never go back
+	g.setPhases(0, 1)
+	if got := g.rate(); got != 7.5 {
+		t.Errorf("expected 7.5 after the first sales phase, got %v", got)
+	}
+}
+
+func TestArrivalsAbandoned(t *testing.T) {
+	g := newArrivalGenerator(&Profile{Arrivals: ArrivalProfile{MaxConcurrentUsers: 1, Patience: 20 * time.Millisecond}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first user keeps the only slot until the load finishes, so the others give up
+	var runs atomic.Int64
+	runUser := func(ctx context.Context, _ *rand.Rand) {
+		runs.Add(1)
+		<-ctx.Done()
+	}
+	for i := 0; i < 3; i++ {
+		g.arrive(ctx, rand.New(rand.NewSource(int64(i))), runUser)
+	}
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	g.runningUsers.Wait()
+
+	stats := g.stats()
+	if stats.Arrivals != 3 || stats.Started != 1 || stats.Abandoned != 2 || stats.PeakOutstanding < 2 {
+		t.Errorf("expected 1 started and 2 abandoned arrivals, got %+v", stats)
+	}
+	if runs.Load() != 1 {
+		t.Errorf("expected 1 user to run, got %d", runs.Load())
+	}
+}
@@ -0,0 +1,212 @@
+package bench
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// Availability labels of /api/schedules
+const (
+	availabilityLots = "lots"
+	availabilityFew  = "few"
+	availabilityNone = "none"
+)
+
+// A section is labelled "few" when at most 1/fewSeatsDivisor of the seats are available.
+const fewSeatsDivisor = 10
+
+// minSeatsForLots returns the fewest available seats of a section labelled "lots".
+func minSeatsForLots(capacity int) int {
+	return capacity/fewSeatsDivisor + 1
+}
+
+// availabilityLedger compares the availability labels of /api/schedules with the seats the benchmark itself reserved.
+// It only counts an upper bound of the seats held by the benchmark, so a label is reported only when
+// it is wrong whatever the result of the pending and uncertain requests is.
+// Seats are counted per schedule, not per section: the reference application takes a reserved seat
+// on every section of the schedule, so a section may be full although no reservation passes it.
+type availabilityLedger struct {
+	line *Line
+	mu   sync.Mutex
+	// held is the number of seats which reservations of the benchmark may hold.
+	// Seats of refunded reservations are kept since the application may not release them (e.g., after the departure).
+	held       map[string]int // key: ScheduleID
+	inFlight   map[string]int // key: ScheduleID, seats requested by /api/reserve without response
+	releases   int64          // number of reservations released so far
+	reported   map[string]bool
+	violations []Violation
+}
+
+func newAvailabilityLedger(line *Line) *availabilityLedger {
+	return &availabilityLedger{
+		line:     line,
+		held:     make(map[string]int),
+		inFlight: make(map[string]int),
+		reported: make(map[string]bool),
+	}
+}
+
+func availabilityKey(scheduleID, section string) string {
+	return scheduleID + "|" + section
+}
+
+// beginReserve counts the seats of a /api/reserve request about to be sent.
+func (l *availabilityLedger) beginReserve(req ReservationReq) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight[req.ScheduleID] += req.NumPeople
+}
+
+// endReserve moves the seats of a /api/reserve request to the held seats.
+// reservation is nil if no seats were reserved. If uncertain is true, the response was lost and
+// the application may have reserved the requested seats.
+func (l *availabilityLedger) endReserve(req ReservationReq, reservation *Reservation, uncertain bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight[req.ScheduleID] -= req.NumPeople
+	switch {
+	case reservation != nil:
+		l.held[reservation.ScheduleID] += len(reservation.Seats)
+	case uncertain:
+		l.held[req.ScheduleID] += req.NumPeople
+	}
+}
+
+// release frees the seats of a reservation whose purchase failed.
+func (l *availabilityLedger) release(reservation Reservation) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.held[reservation.ScheduleID] -= len(reservation.Seats)
+	l.releases++
+}
+
+// releaseCount returns the number of releases so far. Read it before requesting /api/schedules
+// to know whether seats were released while the labels were computed.
+func (l *availabilityLedger) releaseCount() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.releases
+}
+
+// checkNoneLabels reports the sections labelled "none" although the benchmark holds fewer seats of the schedule
+// than the capacity.
+// releases is the result of releaseCount before the schedules were requested. Each section is reported once.
+func (l *availabilityLedger) checkNoneLabels(models *trainModelTable, schedules []TrainSchedule, releases int64) []Violation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The labels may have been computed before the release
+	if l.releases != releases {
+		return nil
+	}
+
+	ids := l.line.StationIDs()
+	var violations []Violation
+	for _, schedule := range schedules {
+		model, ok := models.modelOf(trainNameFromScheduleID(schedule.ID))
+		if !ok {
+			continue
+		}
+		for i := 0; i < len(ids)-1; i++ {
+			for _, pair := range [][2]string{{ids[i], ids[i+1]}, {ids[i+1], ids[i]}} {
+				if schedule.Availability[l.line.legKey(pair[0], pair[1])] != availabilityNone {
+					continue
+				}
+				section := pair[0] + "->" + pair[1]
+				key := availabilityKey(schedule.ID, section)
+				held := l.held[schedule.ID] + l.inFlight[schedule.ID]
+				if held >= model.capacity() || l.reported[key] {
+					continue
+				}
+				l.reported[key] = true
+				violations = append(violations, Violation{
+					Kind:    ViolationNoneLabelWithSeatsLeft,
+					Message: fmt.Sprintf("Schedule %s, Section %s is labelled none, but the benchmark holds only %d of %d seats of the schedule", schedule.ID, section, held, model.capacity()),
+					Details: map[string]string{
+						"schedule_id": schedule.ID,
+						"section":     section,
+						"held_seats":  strconv.Itoa(held),
+						"capacity":    strconv.Itoa(model.capacity()),
+					},
+				})
+			}
+		}
+	}
+	l.violations = append(l.violations, violations...)
+	return violations
+}
+
+// checkLotsLabel flags a reservation of numPeople seats from `from` to `to` which failed with NO_SEAT_AVAILABLE
+// although every section was labelled "lots" with enough seats. It is not a penalty, since other sessions
+// may have taken the seats after the schedule was read. Each schedule and pair of stations is flagged once.
+func (l *availabilityLedger) checkLotsLabel(models *trainModelTable, schedule TrainSchedule, from, to string, numPeople int) (Violation, bool) {
+	model, ok := models.modelOf(trainNameFromScheduleID(schedule.ID))
+	if !ok || numPeople > minSeatsForLots(model.capacity()) {
+		return Violation{}, false
+	}
+	ids, err := l.line.path(from, to)
+	if err != nil {
+		return Violation{}, false
+	}
+	for i := 0; i < len(ids)-1; i++ {
+		if schedule.Availability[l.line.legKey(ids[i], ids[i+1])] != availabilityLots {
+			return Violation{}, false
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := availabilityKey(schedule.ID, from+"=>"+to)
+	if l.reported[key] {
+		return Violation{}, false
+	}
+	l.reported[key] = true
+	violation := Violation{
+		Kind:    ViolationLotsLabelWithoutSeats,
+		Message: fmt.Sprintf("Schedule %s from %s to %s is labelled lots, but reserving %d seats failed with NO_SEAT_AVAILABLE", schedule.ID, from, to, numPeople),
+		Details: map[string]string{
+			"schedule_id":     schedule.ID,
+			"from_station_id": from,
+			"to_station_id":   to,
+			"num_people":      strconv.Itoa(numPeople),
+			"capacity":        strconv.Itoa(model.capacity()),
+		},
+	}
+	l.violations = append(l.violations, violation)
+	return violation, true
+}
+
+// allViolations returns every label reported so far.
+func (l *availabilityLedger) allViolations() []Violation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Violation(nil), l.violations...)
+}
+
+// validateNoneLabels checks the "none" labels of schedules read by user.
+// releases is the result of availabilityLedger.releaseCount before the schedules were requested.
+func (s *Scenario) validateNoneLabels(schedules []TrainSchedule, releases int64, user User) {
+	for _, v := range s.availability.checkNoneLabels(s.trainModels, schedules, releases) {
+		s.log.Error("Wrong availability label", "error", v.Message, "user", user.Name)
+	}
+}
+
+// validateLotsLabel checks the labels of a schedule on which a reservation failed with NO_SEAT_AVAILABLE.
+func (s *Scenario) validateLotsLabel(schedule TrainSchedule, from, to string, numPeople int, user User) {
+	if v, ok := s.availability.checkLotsLabel(s.trainModels, schedule, from, to, numPeople); ok {
+		s.log.Warn("Suspicious availability label", "error", v.Message, "user", user.Name)
+	}
+}
+
+// releaseFailedPurchase frees the seats of a reservation whose purchase failed.
+func (s *Scenario) releaseFailedPurchase(reservationID string) {
+	value, ok := s.ticketLedger.Load(reservationID)
+	if !ok {
+		return
+	}
+	s.availability.release(value.(*ticketRecord).reservation)
+}
@@ -0,0 +1,86 @@
+package bench
+
+import "testing"
+
+func testSchedule(id string, availability map[string]string) TrainSchedule {
+	return TrainSchedule{ID: id, Availability: availability}
+}
+
+func TestMinSeatsForLots(t *testing.T) {
+	// "lots" means more than 10% of the seats are available
+	tests := map[int]int{50: 6, 40: 5, 28: 3, 15: 2, 4: 1}
+	for capacity, want := range tests {
+		if got := minSeatsForLots(capacity); got != want {
+			t.Errorf("minSeatsForLots(%d) = %d, want %d", capacity, got, want)
+		}
+	}
+}
+
+func TestAvailabilityLedgerNoneLabels(t *testing.T) {
+	ledger := newAvailabilityLedger(testLine(t))
+	models, _ := newTrainModelTable([]string{"Luxury-2"})
+
+	// Luxury-2 has 4 seats. 3 of them are reserved on Arena -> Bridge.
+	req := ReservationReq{ScheduleID: "L2001-1", FromStationID: "A", ToStationID: "B", NumPeople: 3}
+	ledger.beginReserve(req)
+	ledger.endReserve(req, &Reservation{ScheduleID: "L2001-1", FromStation: "Arena", ToStation: "Bridge", Seats: []string{"1-A", "1-B", "2-A"}}, false)
+
+	schedules := []TrainSchedule{testSchedule("L2001-1", map[string]string{"Arena->Bridge": "none", "Bridge->Cave": "lots"})}
+	violations := ledger.checkNoneLabels(models, schedules, ledger.releaseCount())
+	if len(violations) != 1 || violations[0].Kind != ViolationNoneLabelWithSeatsLeft || violations[0].Details["section"] != "A->B" {
+		t.Fatalf("unexpected violations: %+v", violations)
+	}
+	// Each section is reported once
+	if violations := ledger.checkNoneLabels(models, schedules, ledger.releaseCount()); len(violations) != 0 {
+		t.Errorf("section reported twice: %+v", violations)
+	}
+
+	// A pending reservation may hold the last seat. Seats are taken on every section of the schedule,
+	// so Cave -> Dock may be full although no reservation passes it.
+	pending := ReservationReq{ScheduleID: "L2001-1", FromStationID: "B", ToStationID: "C", NumPeople: 1}
+	ledger.beginReserve(pending)
+	schedules = []TrainSchedule{testSchedule("L2001-1", map[string]string{"Cave->Dock": "none"})}
+	if violations := ledger.checkNoneLabels(models, schedules, ledger.releaseCount()); len(violations) != 0 {
+		t.Errorf("unexpected violations with a pending reservation: %+v", violations)
+	}
+	// So does a reservation whose response was lost
+	ledger.endReserve(pending, nil, true)
+	if violations := ledger.checkNoneLabels(models, schedules, ledger.releaseCount()); len(violations) != 0 {
+		t.Errorf("unexpected violations with an uncertain reservation: %+v", violations)
+	}
+
+	// Labels read while seats were released are not checked
+	releases := ledger.releaseCount()
+	ledger.release(Reservation{ScheduleID: "L2001-1", FromStation: "Arena", ToStation: "Bridge", Seats: []string{"1-A", "1-B", "2-A"}})
+	if violations := ledger.checkNoneLabels(models, schedules, releases); len(violations) != 0 {
+		t.Errorf("unexpected violations after a release: %+v", violations)
+	}
+	if violations := ledger.checkNoneLabels(models, schedules, ledger.releaseCount()); len(violations) != 1 {
+		t.Errorf("expected 1 violation after the release, got %+v", violations)
+	}
+
+	if got := len(ledger.allViolations()); got != 2 {
+		t.Errorf("expected 2 violations in total, got %d", got)
+	}
+}
+
+func TestAvailabilityLedgerLotsLabel(t *testing.T) {
+	ledger := newAvailabilityLedger(testLine(t))
+	models, _ := newTrainModelTable([]string{"Economy-5"})
+	schedule := testSchedule("E5001-1", map[string]string{"Arena->Bridge": "lots", "Bridge->Cave": "lots", "Cave->Dock": "few"})
+
+	// Economy-5 has at least 6 seats left on a section labelled "lots"
+	if _, ok := ledger.checkLotsLabel(models, schedule, "A", "C", 7); ok {
+		t.Error("flagged a reservation of more seats than lots guarantees")
+	}
+	if _, ok := ledger.checkLotsLabel(models, schedule, "A", "D", 2); ok {
+		t.Error("flagged a reservation through a section labelled few")
+	}
+	v, ok := ledger.checkLotsLabel(models, schedule, "A", "C", 6)
+	if !ok || v.Kind != ViolationLotsLabelWithoutSeats || v.Rule().Penalty != 0 || v.Rule().Fail {
+		t.Fatalf("unexpected result: %+v, %v", v, ok)
+	}
+	if _, ok := ledger.checkLotsLabel(models, schedule, "A", "C", 1); ok {
+		t.Error("flagged the same schedule twice")
+	}
+}
@@ -0,0 +1,205 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/isucon/isucandar/agent"
+)
+
+// DefaultClockSpeed is the speed of the application clock: 1 second in real time is 10 minutes in the application.
+const DefaultClockSpeed = 600
+
+// The application clock stops at 24:00.
+const applicationDayEnd = 24 * time.Hour
+
+// VirtualClock is the clock of the application. It starts at 00:00 when the application is initialized
+// and runs speed times faster than real time.
+type VirtualClock struct {
+	start time.Time
+	speed float64
+}
+
+// NewVirtualClock returns a clock starting at start. DefaultClockSpeed is used if speed is 0.
+func NewVirtualClock(start time.Time, speed float64) *VirtualClock {
+	if speed == 0 {
+		speed = DefaultClockSpeed
+	}
+	return &VirtualClock{start: start, speed: speed}
+}
+
+// elapsed returns the application time since 00:00 at the real time t.
+func (c *VirtualClock) elapsed(t time.Time) time.Duration {
+	d := time.Duration(float64(t.Sub(c.start)) * c.speed)
+	return min(max(d, 0), applicationDayEnd)
+}
+
+// At returns the application clock ("HH:MM") at the real time t.
+func (c *VirtualClock) At(t time.Time) string {
+	d := c.elapsed(t)
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// Now returns the current application clock ("HH:MM").
+func (c *VirtualClock) Now() string {
+	return c.At(time.Now())
+}
+
+// RealTime returns the real time at which the application clock reaches appTime ("HH:MM").
+func (c *VirtualClock) RealTime(appTime string) (time.Time, error) {
+	d, err := parseApplicationTime(appTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return c.start.Add(c.RealDuration(d)), nil
+}
+
+// RealDuration returns the real time it takes for the application clock to advance by d.
+func (c *VirtualClock) RealDuration(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / c.speed)
+}
+
+// parseApplicationTime returns the time since 00:00 of appTime ("HH:MM"). "24:00" is the end of the day.
+func parseApplicationTime(appTime string) (time.Duration, error) {
+	if appTime == "24:00" {
+		return applicationDayEnd, nil
+	}
+	t, err := time.Parse("15:04", appTime)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse application time %s: %w", appTime, err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// drift returns how far appTime, read by a request from start to end, is from the clock in application time.
+// The application truncates its clock to minutes, so anything from the minute of start to end agrees.
+func (c *VirtualClock) drift(appTime string, start, end time.Time) (time.Duration, error) {
+	got, err := parseApplicationTime(appTime)
+	if err != nil {
+		return 0, err
+	}
+	earliest := c.elapsed(start).Truncate(time.Minute)
+	latest := c.elapsed(end)
+	switch {
+	case got < earliest:
+		return earliest - got, nil
+	case got > latest:
+		return got - latest, nil
+	default:
+		return 0, nil
+	}
+}
+
+// ClockStats summarizes the checks of /api/current_time against the clock of the benchmark.
+type ClockStats struct {
+	Checks int64
+	// Drifted is the number of checks off by more than the tolerance
+	Drifted  int64
+	MaxDrift time.Duration
+}
+
+// clockMonitor compares /api/current_time with the clock of the benchmark.
+type clockMonitor struct {
+	clock     *VirtualClock
+	tolerance time.Duration
+	mu        sync.Mutex
+	stats     ClockStats
+}
+
+func newClockMonitor(clock *VirtualClock, tolerance time.Duration) *clockMonitor {
+	return &clockMonitor{clock: clock, tolerance: tolerance}
+}
+
+// check records appTime read by a request from start to end. It returns a violation the first time
+// the application clock is off by more than the tolerance.
+func (m *clockMonitor) check(appTime string, start, end time.Time) (Violation, bool, error) {
+	drift, err := m.clock.drift(appTime, start, end)
+	if err != nil {
+		return Violation{}, false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats.Checks++
+	m.stats.MaxDrift = max(m.stats.MaxDrift, drift)
+	if drift <= m.tolerance {
+		return Violation{}, false, nil
+	}
+	m.stats.Drifted++
+	if m.stats.Drifted > 1 {
+		return Violation{}, false, nil
+	}
+	expected := m.clock.At(end)
+	return Violation{
+		Kind:    ViolationClockDrift,
+		Message: fmt.Sprintf("/api/current_time returned %s, but the clock of the benchmark was %s (drift %s)", appTime, expected, drift),
+		Details: map[string]string{
+			"current_time":  appTime,
+			"expected_time": expected,
+			"drift_minutes": strconv.FormatInt(int64(drift/time.Minute), 10),
+		},
+	}, true, nil
+}
+
+func (m *clockMonitor) snapshot() ClockStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+type CurrentTimeResp struct {
+	CurrentTime string `json:"current_time"`
+}
+
+// checkCurrentTime gets /api/current_time and checks it against the clock of the benchmark.
+func (s *Scenario) checkCurrentTime(ctx context.Context, agent *agent.Agent, user User) (HttpResponse, error) {
+	start := time.Now()
+	resp, err := HttpGet(ctx, agent, "/api/current_time")
+	end := time.Now()
+	if err != nil || resp.StatusCode != 200 {
+		return resp, err
+	}
+	var currentTime CurrentTimeResp
+	if err := json.Unmarshal(resp.Body, &currentTime); err != nil {
+		return resp, fmt.Errorf("failed to unmarshal /api/current_time response: %w", err)
+	}
+	v, drifted, err := s.clockMonitor.check(currentTime.CurrentTime, start, end)
+	if err != nil {
+		return resp, err
+	}
+	if drifted {
+		s.log.Error("Application clock drifted", "error", v.Message, "user", user.Name)
+		s.violations.add(v)
+	}
+	return resp, nil
+}
+
+// RunClockScenario checks /api/current_time periodically until ctx is done.
+func (s *Scenario) RunClockScenario(ctx context.Context) {
+	interval := s.profile.Clock.DriftCheckInterval
+	if interval == 0 {
+		return
+	}
+	agent, err := s.newAgent()
+	if err != nil {
+		s.log.Error("Failed to create agent", "error", err.Error())
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.checkCurrentTime(ctx, agent, User{Name: "clock"}); err != nil && ShouldLogHTTPError(ctx, err) {
+				s.log.Error("Failed to check /api/current_time", "error", err.Error())
+			}
+		}
+	}
+}
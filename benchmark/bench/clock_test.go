@@ -0,0 +1,81 @@
+package bench
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVirtualClock(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		speed   float64
+		elapsed time.Duration
+		want    string
+	}{
+		{"start", 0, 0, "00:00"},
+		{"before start", 0, -time.Second, "00:00"},
+		{"default speed", 0, 6*time.Second + 500*time.Millisecond, "01:05"},
+		{"end of the day", DefaultClockSpeed, 200 * time.Second, "24:00"},
+		{"double speed", 2 * DefaultClockSpeed, 6 * time.Second, "02:00"},
+	}
+	for _, tt := range tests {
+		clock := NewVirtualClock(start, tt.speed)
+		if got := clock.At(start.Add(tt.elapsed)); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+
+	clock := NewVirtualClock(start, 2*DefaultClockSpeed)
+	got, err := clock.RealTime("10:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := start.Add(31500 * time.Millisecond); !got.Equal(want) {
+		t.Errorf("expected 10:30 at %s, got %s", want, got)
+	}
+	if _, err := clock.RealTime("noon"); err == nil {
+		t.Error("expected an error for an invalid time")
+	}
+	if got := clock.RealDuration(10 * time.Minute); got != 500*time.Millisecond {
+		t.Errorf("expected 10 minutes to take 500ms, got %s", got)
+	}
+}
+
+func TestClockMonitor(t *testing.T) {
+	start := time.Now()
+	monitor := newClockMonitor(NewVirtualClock(start, DefaultClockSpeed), 10*time.Minute)
+	// The request is sent at 01:00:30 and answered at 01:02
+	sent, received := start.Add(6050*time.Millisecond), start.Add(6200*time.Millisecond)
+	tests := []struct {
+		name        string
+		currentTime string
+		want        bool
+	}{
+		{"truncated to the minute", "01:00", false},
+		{"answered late", "01:02", false},
+		{"within the tolerance", "01:12", false},
+		{"ahead", "01:30", true},
+		{"behind", "00:30", false}, // flagged only once
+	}
+	for _, tt := range tests {
+		v, ok, err := monitor.check(tt.currentTime, sent, received)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if ok != tt.want {
+			t.Errorf("%s: expected a violation %t, got %+v", tt.name, tt.want, v)
+		}
+		if ok && v.Kind != ViolationClockDrift {
+			t.Errorf("%s: expected %s, got %s", tt.name, ViolationClockDrift, v.Kind)
+		}
+	}
+
+	stats := monitor.snapshot()
+	if stats.Checks != 5 || stats.Drifted != 2 || stats.MaxDrift != 30*time.Minute {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if _, _, err := monitor.check("later", sent, received); err == nil {
+		t.Error("expected an error for an invalid time")
+	}
+}
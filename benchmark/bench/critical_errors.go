@@ -0,0 +1,74 @@
+package bench
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/showwin/ISHOCON3/benchmark/bench/logger"
+)
+
+// ErrorCategory tells which check raised a critical error.
+type ErrorCategory string
+
+const (
+	ErrorCategoryPreValidation   ErrorCategory = "pre_validation"
+	ErrorCategoryStatsValidation ErrorCategory = "stats_validation"
+	ErrorCategoryAdmin           ErrorCategory = "admin"
+	ErrorCategoryRefund          ErrorCategory = "refund"
+	ErrorCategoryScheduleCount   ErrorCategory = "schedule_count"
+	ErrorCategoryDoubleBooking   ErrorCategory = "double_booking"
+	ErrorCategorySecurity        ErrorCategory = "security"
+)
+
+// CriticalError is an error which stops the benchmark.
+type CriticalError struct {
+	Category ErrorCategory
+	Message  string
+	At       time.Time
+}
+
+// errorCollector keeps every critical error of a run. The first one cancels the run.
+// Reporting never blocks, so any number of goroutines may report errors at any time.
+type errorCollector struct {
+	log     logger.Logger
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	errors  []CriticalError
+	stopped chan struct{}
+}
+
+func newErrorCollector(log logger.Logger, cancel context.CancelFunc) *errorCollector {
+	return &errorCollector{
+		log:     log,
+		cancel:  cancel,
+		stopped: make(chan struct{}),
+	}
+}
+
+// report records err and cancels the run if it is the first critical error.
+func (c *errorCollector) report(category ErrorCategory, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.errors = append(c.errors, CriticalError{Category: category, Message: err.Error(), At: time.Now()})
+	if len(c.errors) > 1 {
+		c.log.Error("Critical error occurred", "category", category, "error", err.Error())
+		return
+	}
+	c.log.Error("Critical error occurred, stopping benchmark", "category", category, "error", err.Error())
+	close(c.stopped)
+	c.cancel()
+}
+
+// done returns a channel which is closed when the first critical error is reported.
+func (c *errorCollector) done() <-chan struct{} {
+	return c.stopped
+}
+
+// all returns the critical errors in the order they were reported.
+func (c *errorCollector) all() []CriticalError {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]CriticalError(nil), c.errors...)
+}
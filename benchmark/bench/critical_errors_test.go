@@ -0,0 +1,52 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/showwin/ISHOCON3/benchmark/bench/logger"
+)
+
+func TestErrorCollector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := newErrorCollector(logger.GetLogger("error"), cancel)
+
+	select {
+	case <-c.done():
+		t.Fatal("expected the collector not to be stopped before any error")
+	default:
+	}
+
+	c.report(ErrorCategoryRefund, errors.New("refund failed"))
+	<-c.done()
+	if ctx.Err() == nil {
+		t.Error("expected the first critical error to cancel the run")
+	}
+
+	// Later errors from many goroutines never block and are all kept
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.report(ErrorCategoryStatsValidation, errors.New("total_sales too old"))
+		}()
+	}
+	wg.Wait()
+
+	errs := c.all()
+	if len(errs) != 11 {
+		t.Fatalf("expected 11 errors, got %d", len(errs))
+	}
+	if errs[0].Category != ErrorCategoryRefund || errs[0].Message != "refund failed" {
+		t.Errorf("expected the refund error first, got %+v", errs[0])
+	}
+	for _, e := range errs[1:] {
+		if e.Category != ErrorCategoryStatsValidation {
+			t.Errorf("unexpected category %s", e.Category)
+		}
+	}
+}
@@ -16,3 +16,6 @@ var TrainConfigsTicketSoldCSV string
 
 //go:embed train_configs_sales.csv
 var TrainConfigsSalesCSV string
+
+//go:embed default_profile.yaml
+var DefaultProfileYAML string
@@ -0,0 +1,102 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/isucon/isucandar/agent"
+)
+
+// Probability that a passenger tries a used entry token at the gate again
+const entryReplayRate = 0.1
+
+// Cases of entry replays
+const (
+	entryReplaySameToken        = "same_token"
+	entryReplayAfterRefund      = "after_refund"
+	entryReplayOtherReservation = "other_reservation"
+	entryReplayAdversary        = "adversary"
+)
+
+// entryReplay is a POST /api/entry with a token which was already used, and the status the application must return.
+type entryReplay struct {
+	Case          string
+	ReservationID string // reservation the token was issued for
+	Token         string
+	Expected      string
+}
+
+// newEntryReplay returns a replay of the token of a reservation departing at departureAt ("HH:MM").
+// A departed train is rejected before the entry is looked up, so the expected status depends on the clock.
+func (s *Scenario) newEntryReplay(replayCase, reservationID, token, departureAt string) (entryReplay, error) {
+	departedAt, err := s.clock.RealTime(departureAt)
+	if err != nil {
+		return entryReplay{}, err
+	}
+	expected := "already_entered"
+	if time.Now().After(departedAt) {
+		expected = "train_departed"
+	}
+	return entryReplay{Case: replayCase, ReservationID: reservationID, Token: token, Expected: expected}, nil
+}
+
+// pickOtherEnteredTicket returns the token of a random entered reservation other than reservationID.
+func (s *Scenario) pickOtherEnteredTicket(rng *rand.Rand, reservationID string) (*ticketRecord, string, bool) {
+	record, ok := s.pickVictimTicket(rng, User{}, func(r *ticketRecord) bool {
+		_, entered := r.enteredToken()
+		return entered && r.reservation.ReservationID != reservationID
+	})
+	if !ok {
+		return nil, "", false
+	}
+	token, _ := record.enteredToken()
+	return record, token, true
+}
+
+// replayEntryToken replays the token of a reservation departing at departureAt ("HH:MM").
+func (s *Scenario) replayEntryToken(ctx context.Context, agent *agent.Agent, replayCase, reservationID, token, departureAt string, user User) {
+	replay, err := s.newEntryReplay(replayCase, reservationID, token, departureAt)
+	if err != nil {
+		s.log.Error("Failed to prepare entry replay", "case", replayCase, "error", err.Error(), "user", user.Name)
+		return
+	}
+	s.replayEntry(ctx, agent, replay, user)
+}
+
+// replayEntry posts the token of replay and checks the application rejects it.
+// An accepted entry is flagged as a duplicate entry. A rejection with another status is only logged,
+// since the train may depart between deciding the expected status and the request.
+func (s *Scenario) replayEntry(ctx context.Context, agent *agent.Agent, replay entryReplay, user User) {
+	resp, err := s.enterGate(ctx, agent, EntryReq{EntryToken: replay.Token}, user)
+	if err != nil {
+		if ShouldLogHTTPError(ctx, err) {
+			s.log.Warn("Entry replay was rejected with an error", "case", replay.Case, "expected", replay.Expected, "error", err.Error(), "user", user.Name)
+		}
+		return
+	}
+	switch resp.Status {
+	case replay.Expected:
+		s.log.Debug("Entry replay was rejected", "case", replay.Case, "status", resp.Status, "user", user.Name)
+	case "success":
+		s.recordDuplicateEntry(replay, user)
+	default:
+		s.log.Warn("Entry replay was rejected with an unexpected status", "case", replay.Case, "expected", replay.Expected, "status", resp.Status, "user", user.Name)
+	}
+}
+
+// recordDuplicateEntry records an entry the application should have rejected.
+func (s *Scenario) recordDuplicateEntry(replay entryReplay, user User) {
+	v := Violation{
+		Kind:    ViolationDuplicateEntry,
+		Message: fmt.Sprintf("entry token of reservation %s entered again (%s), but expected %s", replay.ReservationID, replay.Case, replay.Expected),
+		Details: map[string]string{
+			"case":            replay.Case,
+			"reservation_id":  replay.ReservationID,
+			"expected_status": replay.Expected,
+		},
+	}
+	s.log.Error("Duplicate entry detected!", "error", v.Message, "user", user.Name)
+	s.violations.add(v)
+}
@@ -0,0 +1,107 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/showwin/ISHOCON3/benchmark/bench/logger"
+)
+
+func TestNewEntryReplayExpectedStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		initializedAt time.Time
+		departureAt   string
+		want          string
+	}{
+		// The application clock is 00:00
+		{"before departure", time.Now(), "10:00", "already_entered"},
+		// The application clock is 12:00
+		{"after departure", time.Now().Add(-72 * time.Second), "10:00", "train_departed"},
+	}
+	for _, tt := range tests {
+		s := &Scenario{clock: NewVirtualClock(tt.initializedAt, DefaultClockSpeed)}
+		replay, err := s.newEntryReplay(entryReplaySameToken, "r1", "token-r1", tt.departureAt)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if replay.Expected != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, replay.Expected)
+		}
+	}
+
+	s := &Scenario{clock: NewVirtualClock(time.Now(), DefaultClockSpeed)}
+	if _, err := s.newEntryReplay(entryReplaySameToken, "r1", "token-r1", "invalid"); err == nil {
+		t.Error("expected an error for an invalid departure time")
+	}
+}
+
+func TestReplayEntry(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		body          map[string]string
+		wantViolation bool
+	}{
+		{"accepted", http.StatusOK, map[string]string{"status": "success"}, true},
+		{"already entered", http.StatusOK, map[string]string{"status": "already_entered"}, false},
+		{"departed", http.StatusOK, map[string]string{"status": "train_departed"}, false},
+		{"unknown token", http.StatusNotFound, map[string]string{"detail": "Not found"}, false},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			json.NewEncoder(w).Encode(tt.body)
+		}))
+		s := &Scenario{
+			targetURL:      server.URL,
+			log:            logger.GetLogger("error"),
+			violations:     &violationRecorder{},
+			criticalErrors: newErrorCollector(logger.GetLogger("error"), func() {}),
+			connections:    newConnectionPool(HTTPProfile{}, newRequestRecorder()),
+		}
+		a, err := s.newAgent()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s.replayEntry(context.Background(), a, entryReplay{Case: entryReplaySameToken, ReservationID: "r1", Token: "token-r1", Expected: "already_entered"}, User{Name: "alice"})
+		server.Close()
+
+		violations := s.violations.all()
+		if !tt.wantViolation {
+			if len(violations) != 0 {
+				t.Errorf("%s: expected no violations, got %+v", tt.name, violations)
+			}
+			continue
+		}
+		if len(violations) != 1 || violations[0].Kind != ViolationDuplicateEntry || violations[0].Rule().Fail || violations[0].Rule().Penalty == 0 {
+			t.Errorf("%s: expected a penalized duplicate entry, got %+v", tt.name, violations)
+		}
+		if errs := s.criticalErrors.all(); len(errs) != 0 {
+			t.Errorf("%s: expected the duplicate entry not to stop the benchmark, got %+v", tt.name, errs)
+		}
+	}
+}
+
+func TestPickOtherEnteredTicket(t *testing.T) {
+	s := newAdversaryTestScenario(t, http.StatusOK, nil)
+	s.recordReservation(User{Name: "carol"}, Reservation{ReservationID: "r3", ScheduleID: "E5001-1", FromStation: "Arena", ToStation: "Bridge"})
+	s.updateTicketState("r3", ticketPurchased)
+	s.recordEntry("r3", "token-r3")
+
+	rng := newRandStream(1, 0)
+	for i := 0; i < 10; i++ {
+		record, token, ok := s.pickOtherEnteredTicket(rng, "r2")
+		if !ok || record.reservation.ReservationID != "r3" || token != "token-r3" {
+			t.Fatalf("expected the entered ticket r3, got %v %s %t", record, token, ok)
+		}
+	}
+	if _, _, ok := s.pickOtherEnteredTicket(rng, "r3"); !ok {
+		t.Error("expected the entered ticket r2")
+	}
+}
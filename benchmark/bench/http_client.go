@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"strings"
 
 	"github.com/isucon/isucandar/agent"
@@ -28,6 +29,7 @@ func ShouldLogHTTPError(ctx context.Context, err error) bool {
 
 type HttpResponse struct {
 	StatusCode int
+	Header     http.Header
 	Body       []byte
 }
 
@@ -50,6 +52,7 @@ func HttpGet(ctx context.Context, agent *agent.Agent, path string) (HttpResponse
 
 	httpResp := HttpResponse{
 		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
 		Body:       body,
 	}
 
@@ -77,6 +80,7 @@ func HttpPost(ctx context.Context, agent *agent.Agent, path string, body io.Read
 
 	httpResp := HttpResponse{
 		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
 		Body:       respBody,
 	}
 
@@ -0,0 +1,314 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Latency histogram buckets grow exponentially from 100µs, so percentiles are accurate within 10%.
+const (
+	latencyBucketBase   = 100 * time.Microsecond
+	latencyBucketGrowth = 1.1
+	latencyBucketCount  = 160 // up to about 7 minutes
+)
+
+var qrCodeRoute = regexp.MustCompile(`^/api/qr/[^/]+\.png$`)
+
+// normalizeRoute converts a request path to the route it is aggregated into.
+func normalizeRoute(path string) string {
+	if idx := strings.IndexByte(path, '?'); idx != -1 {
+		path = path[:idx]
+	}
+	if qrCodeRoute.MatchString(path) {
+		return "/api/qr/{id}.png"
+	}
+	return path
+}
+
+type latencyHistogram struct {
+	buckets [latencyBucketCount]int64
+	count   int64
+	sum     time.Duration
+	max     time.Duration
+}
+
+func latencyBucket(d time.Duration) int {
+	if d <= latencyBucketBase {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(float64(d)/float64(latencyBucketBase)) / math.Log(latencyBucketGrowth)))
+	return min(i, latencyBucketCount-1)
+}
+
+func latencyBucketUpperBound(i int) time.Duration {
+	return time.Duration(float64(latencyBucketBase) * math.Pow(latencyBucketGrowth, float64(i)))
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	h.buckets[latencyBucket(d)]++
+	h.count++
+	h.sum += d
+	h.max = max(h.max, d)
+}
+
+func (h *latencyHistogram) merge(other *latencyHistogram) {
+	for i := range h.buckets {
+		h.buckets[i] += other.buckets[i]
+	}
+	h.count += other.count
+	h.sum += other.sum
+	h.max = max(h.max, other.max)
+}
+
+// percentile returns the upper bound of the bucket containing the q-th quantile (0 < q <= 1).
+func (h *latencyHistogram) percentile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.count)))
+	var cumulative int64
+	for i, count := range h.buckets {
+		cumulative += count
+		if cumulative >= rank {
+			return min(latencyBucketUpperBound(i), h.max)
+		}
+	}
+	return h.max
+}
+
+func (h *latencyHistogram) stats() LatencyStats {
+	return LatencyStats{
+		P50: h.percentile(0.5),
+		P90: h.percentile(0.9),
+		P99: h.percentile(0.99),
+		Max: h.max,
+	}
+}
+
+type routeKey struct {
+	method string
+	route  string
+}
+
+type routeStats struct {
+	byStatus         map[int]*latencyHistogram
+	timeouts         int64
+	connectionErrors int64
+}
+
+// requestRecorder aggregates the outcome of every request of a run.
+// The agents of the run record their requests through recordingTransport.
+type requestRecorder struct {
+	mu     sync.Mutex
+	routes map[routeKey]*routeStats
+}
+
+func newRequestRecorder() *requestRecorder {
+	return &requestRecorder{routes: make(map[routeKey]*routeStats)}
+}
+
+func (r *requestRecorder) routeStats(method, path string) *routeStats {
+	key := routeKey{method: method, route: normalizeRoute(path)}
+	stats, ok := r.routes[key]
+	if !ok {
+		stats = &routeStats{byStatus: make(map[int]*latencyHistogram)}
+		r.routes[key] = stats
+	}
+	return stats
+}
+
+// recordResponse records a request which got a response with the status code.
+func (r *requestRecorder) recordResponse(method, path string, statusCode int, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.routeStats(method, path)
+	histogram, ok := stats.byStatus[statusCode]
+	if !ok {
+		histogram = &latencyHistogram{}
+		stats.byStatus[statusCode] = histogram
+	}
+	histogram.observe(latency)
+}
+
+// recordError records a request which did not get a response.
+// Requests cancelled by the benchmark itself are not the application's fault and are ignored.
+func (r *requestRecorder) recordError(ctx context.Context, method, path string, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.routeStats(method, path)
+	if isTimeoutError(err) {
+		stats.timeouts++
+	} else {
+		stats.connectionErrors++
+	}
+}
+
+// recordingTransport records every request sent through it to the recorder of the run.
+// It also enforces the timeout of the request, so a timeout is told apart from a request cancelled by the benchmark.
+type recordingTransport struct {
+	*http.Transport
+	requests *requestRecorder
+	timeout  time.Duration
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	parent := req.Context()
+	ctx, cancel := context.WithTimeout(parent, t.timeout)
+	start := time.Now()
+	resp, err := t.Transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		t.requests.recordError(parent, req.Method, req.URL.Path, err)
+		return nil, err
+	}
+	resp.Body = &recordedBody{
+		ReadCloser: resp.Body,
+		finish: func(err error) {
+			if err != nil {
+				t.requests.recordError(parent, req.Method, req.URL.Path, err)
+				return
+			}
+			t.requests.recordResponse(req.Method, req.URL.Path, resp.StatusCode, time.Since(start))
+		},
+		cancel: cancel,
+	}
+	return resp, nil
+}
+
+// recordedBody records the request once its response body is read to the end, fails or is closed.
+type recordedBody struct {
+	io.ReadCloser
+	once   sync.Once
+	finish func(err error)
+	cancel context.CancelFunc
+}
+
+func (b *recordedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(func() { b.finish(nil) })
+	} else if err != nil {
+		b.once.Do(func() { b.finish(err) })
+	}
+	return n, err
+}
+
+func (b *recordedBody) Close() error {
+	b.once.Do(func() { b.finish(nil) })
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func isTimeoutError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// totals returns the number of all requests and the requests which failed (non-2xx, timeout or connection error).
+func (r *requestRecorder) totals() (requests int64, errors int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, stats := range r.routes {
+		for statusCode, histogram := range stats.byStatus {
+			requests += histogram.count
+			if statusCode < 200 || statusCode >= 300 {
+				errors += histogram.count
+			}
+		}
+		requests += stats.timeouts + stats.connectionErrors
+		errors += stats.timeouts + stats.connectionErrors
+	}
+	return requests, errors
+}
+
+// LatencyStats summarizes a latency histogram.
+type LatencyStats struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+// StatusStats is the latency of the responses with a status code.
+type StatusStats struct {
+	StatusCode int
+	Count      int64
+	LatencyStats
+}
+
+// EndpointStats is the outcome of the requests to a route.
+type EndpointStats struct {
+	Method string
+	Route  string
+	// Responses is the number of requests which got a response
+	Responses        int64
+	Non2xx           int64
+	Timeouts         int64
+	ConnectionErrors int64
+	// LatencyStats is the latency of all responses regardless of the status code
+	LatencyStats
+	ByStatus []StatusStats
+}
+
+// Requests returns the number of requests sent to the route.
+func (e EndpointStats) Requests() int64 {
+	return e.Responses + e.Timeouts + e.ConnectionErrors
+}
+
+// summary returns the stats of all routes sorted by route and method.
+func (r *requestRecorder) summary() []EndpointStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	endpoints := make([]EndpointStats, 0, len(r.routes))
+	for key, stats := range r.routes {
+		endpoint := EndpointStats{
+			Method:           key.method,
+			Route:            key.route,
+			Timeouts:         stats.timeouts,
+			ConnectionErrors: stats.connectionErrors,
+		}
+		var all latencyHistogram
+		for statusCode, histogram := range stats.byStatus {
+			all.merge(histogram)
+			if statusCode < 200 || statusCode >= 300 {
+				endpoint.Non2xx += histogram.count
+			}
+			endpoint.ByStatus = append(endpoint.ByStatus, StatusStats{
+				StatusCode:   statusCode,
+				Count:        histogram.count,
+				LatencyStats: histogram.stats(),
+			})
+		}
+		endpoint.Responses = all.count
+		endpoint.LatencyStats = all.stats()
+		sort.Slice(endpoint.ByStatus, func(i, j int) bool {
+			return endpoint.ByStatus[i].StatusCode < endpoint.ByStatus[j].StatusCode
+		})
+		endpoints = append(endpoints, endpoint)
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].Route != endpoints[j].Route {
+			return endpoints[i].Route < endpoints[j].Route
+		}
+		return endpoints[i].Method < endpoints[j].Method
+	})
+	return endpoints
+}
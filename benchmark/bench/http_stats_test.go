@@ -0,0 +1,131 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNormalizeRoute(t *testing.T) {
+	tests := map[string]string{
+		"/api/qr/3f2a9c.png":       "/api/qr/{id}.png",
+		"/api/schedules":           "/api/schedules",
+		"/api/schedules?from=A":    "/api/schedules",
+		"/api/qr/3f2a9c.png/extra": "/api/qr/3f2a9c.png/extra",
+	}
+	for path, want := range tests {
+		if got := normalizeRoute(path); got != want {
+			t.Errorf("normalizeRoute(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestLatencyHistogramPercentile(t *testing.T) {
+	var h latencyHistogram
+	for i := 1; i <= 100; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+
+	check := func(name string, got, want time.Duration) {
+		// Buckets are 10% wide
+		if got < want || float64(got) > float64(want)*1.1 {
+			t.Errorf("%s = %v, want about %v", name, got, want)
+		}
+	}
+	stats := h.stats()
+	check("p50", stats.P50, 50*time.Millisecond)
+	check("p90", stats.P90, 90*time.Millisecond)
+	check("p99", stats.P99, 99*time.Millisecond)
+	if stats.Max != 100*time.Millisecond {
+		t.Errorf("max = %v, want 100ms", stats.Max)
+	}
+}
+
+func TestRequestRecorderSummary(t *testing.T) {
+	r := newRequestRecorder()
+	ctx := context.Background()
+	r.recordResponse("GET", "/api/qr/a.png", 200, 10*time.Millisecond)
+	r.recordResponse("GET", "/api/qr/b.png", 404, 20*time.Millisecond)
+	r.recordError(ctx, "GET", "/api/qr/c.png", context.DeadlineExceeded)
+	r.recordError(ctx, "GET", "/api/qr/d.png", errors.New("connection refused"))
+	r.recordResponse("POST", "/api/login", 200, time.Millisecond)
+
+	// Requests cancelled by the benchmark are ignored
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	r.recordError(cancelled, "GET", "/api/qr/e.png", context.Canceled)
+
+	endpoints := r.summary()
+	if len(endpoints) != 2 {
+		t.Fatalf("expected 2 routes, got %d", len(endpoints))
+	}
+	qr := endpoints[1]
+	if qr.Route != "/api/qr/{id}.png" {
+		t.Fatalf("unexpected route order: %+v", endpoints)
+	}
+	if qr.Requests() != 4 || qr.Non2xx != 1 || qr.Timeouts != 1 || qr.ConnectionErrors != 1 {
+		t.Errorf("unexpected counts: %+v", qr)
+	}
+	if len(qr.ByStatus) != 2 || qr.ByStatus[0].StatusCode != 200 || qr.ByStatus[1].Max != 20*time.Millisecond {
+		t.Errorf("unexpected status breakdown: %+v", qr.ByStatus)
+	}
+}
+
+func TestRecordingTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	// Each run records only the requests of its own agents
+	first, second := newRequestRecorder(), newRequestRecorder()
+	a, err := newConnectionPool(HTTPProfile{}, first).newAgent(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.HttpClient.Transport.(*recordingTransport).timeout = 50 * time.Millisecond
+	b, err := newConnectionPool(HTTPProfile{}, second).newAgent(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if _, err := HttpGet(ctx, a, "/api/schedules"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := HttpGet(ctx, a, "/slow"); err == nil {
+		t.Error("expected the slow request to time out")
+	}
+	if _, err := HttpPost(ctx, b, "/api/login", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests cancelled by the benchmark are ignored
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	HttpGet(cancelled, a, "/slow")
+
+	endpoints := first.summary()
+	if len(endpoints) != 2 {
+		t.Fatalf("expected 2 routes in the first run, got %+v", endpoints)
+	}
+	if e := endpoints[0]; e.Route != "/api/schedules" || e.Responses != 1 || e.Non2xx != 1 {
+		t.Errorf("unexpected stats of /api/schedules: %+v", e)
+	}
+	if e := endpoints[1]; e.Route != "/slow" || e.Timeouts != 1 || e.ConnectionErrors != 0 {
+		t.Errorf("unexpected stats of /slow: %+v", e)
+	}
+	if endpoints := second.summary(); len(endpoints) != 1 || endpoints[0].Route != "/api/login" {
+		t.Errorf("expected only /api/login in the second run, got %+v", endpoints)
+	}
+}
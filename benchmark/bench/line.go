@@ -0,0 +1,193 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/isucon/isucandar/agent"
+)
+
+type Station struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type StationsResp struct {
+	Stations []Station `json:"stations"`
+}
+
+// Line is the topology of a linear railway line. Stations are ordered from one end to the other
+// as returned by /api/stations, and trains run both directions between adjacent stations.
+type Line struct {
+	stations  []Station
+	indexByID map[string]int
+	idByName  map[string]string
+}
+
+func newLine(stations []Station) (*Line, error) {
+	if len(stations) < 2 {
+		return nil, fmt.Errorf("a line needs at least 2 stations, got %d", len(stations))
+	}
+	line := &Line{
+		stations:  append([]Station(nil), stations...),
+		indexByID: make(map[string]int, len(stations)),
+		idByName:  make(map[string]string, len(stations)),
+	}
+	for i, station := range stations {
+		if station.ID == "" || station.Name == "" {
+			return nil, fmt.Errorf("station %d has an empty id or name", i)
+		}
+		if _, ok := line.indexByID[station.ID]; ok {
+			return nil, fmt.Errorf("duplicate station id: %s", station.ID)
+		}
+		if _, ok := line.idByName[station.Name]; ok {
+			return nil, fmt.Errorf("duplicate station name: %s", station.Name)
+		}
+		line.indexByID[station.ID] = i
+		line.idByName[station.Name] = station.ID
+	}
+	return line, nil
+}
+
+// fetchLine reads the line topology from /api/stations.
+func fetchLine(ctx context.Context, agent *agent.Agent) (*Line, error) {
+	resp, err := HttpGet(ctx, agent, "/api/stations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get /api/stations: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("got %d status code from /api/stations", resp.StatusCode)
+	}
+	var stationsResp StationsResp
+	if err := json.Unmarshal(resp.Body, &stationsResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal /api/stations response: %w", err)
+	}
+	return newLine(stationsResp.Stations)
+}
+
+// StationIDs returns the IDs of the stations in line order.
+func (l *Line) StationIDs() []string {
+	ids := make([]string, len(l.stations))
+	for i, station := range l.stations {
+		ids[i] = station.ID
+	}
+	return ids
+}
+
+// matches reports whether stations describe the same line.
+func (l *Line) matches(stations []Station) bool {
+	if len(stations) != len(l.stations) {
+		return false
+	}
+	for i := range stations {
+		if stations[i] != l.stations[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// stationID converts station name to ID (e.g., "Arena" -> "A"). Returns "" for unknown names.
+func (l *Line) stationID(name string) string {
+	return l.idByName[name]
+}
+
+// stationName converts station ID to name (e.g., "A" -> "Arena"). Returns "" for unknown IDs.
+func (l *Line) stationName(id string) string {
+	idx, ok := l.indexByID[id]
+	if !ok {
+		return ""
+	}
+	return l.stations[idx].Name
+}
+
+// distance returns the number of sections between two stations.
+func (l *Line) distance(from, to string) int {
+	d := l.indexByID[to] - l.indexByID[from]
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// path returns the station IDs passed from `from` to `to`, both inclusive (e.g., D->B becomes [D, C, B]).
+func (l *Line) path(from, to string) ([]string, error) {
+	fromIdx, ok := l.indexByID[from]
+	if !ok {
+		return nil, fmt.Errorf("unknown station: %s", from)
+	}
+	toIdx, ok := l.indexByID[to]
+	if !ok {
+		return nil, fmt.Errorf("unknown station: %s", to)
+	}
+	if fromIdx == toIdx {
+		return nil, errors.New("departure and arrival stations are the same")
+	}
+
+	step := 1
+	if fromIdx > toIdx {
+		step = -1
+	}
+	var ids []string
+	for i := fromIdx; i != toIdx+step; i += step {
+		ids = append(ids, l.stations[i].ID)
+	}
+	return ids, nil
+}
+
+// sections returns the sections between adjacent stations passed from `from` to `to`
+// (e.g., A->D becomes ["A->B", "B->C", "C->D"]). Returns nil for an invalid pair of stations.
+func (l *Line) sections(from, to string) []string {
+	ids, err := l.path(from, to)
+	if err != nil {
+		return nil
+	}
+	sections := make([]string, 0, len(ids)-1)
+	for i := 0; i < len(ids)-1; i++ {
+		sections = append(sections, ids[i]+"->"+ids[i+1])
+	}
+	return sections
+}
+
+// legKey returns the key of the schedule maps for a section between adjacent stations (e.g., "Arena->Bridge").
+func (l *Line) legKey(from, to string) string {
+	return l.stationName(from) + "->" + l.stationName(to)
+}
+
+// departureAt returns when the train of the schedule departs `from` toward `to`. Returns "" if unknown.
+func (l *Line) departureAt(schedule TrainSchedule, from, to string) string {
+	ids, err := l.path(from, to)
+	if err != nil {
+		return ""
+	}
+	return schedule.DepartureAt[l.legKey(ids[0], ids[1])]
+}
+
+// isAvailable reports whether every section from `from` to `to` has seats left.
+func (l *Line) isAvailable(schedule TrainSchedule, from, to string) bool {
+	ids, err := l.path(from, to)
+	if err != nil {
+		return false
+	}
+	for i := 0; i < len(ids)-1; i++ {
+		availability, ok := schedule.Availability[l.legKey(ids[i], ids[i+1])]
+		if !ok || availability == "none" {
+			return false
+		}
+	}
+	return true
+}
+
+// fareDistance returns the number of sections charged for a trip from `from` to `to`.
+// As in the reference application, a train runs to the last station and back, so a trip toward
+// the first station is charged via the last station (e.g., D->A is D->E->D->C->B->A, 5 sections).
+func (l *Line) fareDistance(from, to string) int {
+	fromIdx, toIdx := l.indexByID[from], l.indexByID[to]
+	if fromIdx <= toIdx {
+		return toIdx - fromIdx
+	}
+	last := len(l.stations) - 1
+	return (last - fromIdx) + (last - toIdx)
+}
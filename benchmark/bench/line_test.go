@@ -0,0 +1,78 @@
+package bench
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testLine returns the line of the reference application.
+func testLine(t *testing.T) *Line {
+	t.Helper()
+	line, err := newLine([]Station{
+		{ID: "A", Name: "Arena"},
+		{ID: "B", Name: "Bridge"},
+		{ID: "C", Name: "Cave"},
+		{ID: "D", Name: "Dock"},
+		{ID: "E", Name: "Edge"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create line: %v", err)
+	}
+	return line
+}
+
+func TestLineSections(t *testing.T) {
+	line := testLine(t)
+	tests := []struct {
+		from, to string
+		want     []string
+	}{
+		{"A", "D", []string{"A->B", "B->C", "C->D"}},
+		{"D", "B", []string{"D->C", "C->B"}},
+		{"A", "A", nil},
+		{"A", "X", nil},
+	}
+	for _, tt := range tests {
+		if got := line.sections(tt.from, tt.to); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sections(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+	if got := line.distance("E", "B"); got != 3 {
+		t.Errorf("distance(E, B) = %d, want 3", got)
+	}
+}
+
+func TestLineSchedule(t *testing.T) {
+	line := testLine(t)
+	schedule := TrainSchedule{
+		ID:           "E5001-1",
+		Availability: map[string]string{"Arena->Bridge": "lots", "Bridge->Cave": "none", "Cave->Bridge": "few", "Bridge->Arena": "lots"},
+		DepartureAt:  map[string]string{"Arena->Bridge": "01:00", "Bridge->Cave": "01:20", "Cave->Bridge": "03:00", "Bridge->Arena": "03:20"},
+	}
+
+	if got := line.departureAt(schedule, "B", "E"); got != "01:20" {
+		t.Errorf("departureAt(B, E) = %s, want 01:20", got)
+	}
+	if got := line.departureAt(schedule, "C", "A"); got != "03:00" {
+		t.Errorf("departureAt(C, A) = %s, want 03:00", got)
+	}
+	if !line.isAvailable(schedule, "C", "A") {
+		t.Error("C -> A should be available")
+	}
+	if line.isAvailable(schedule, "A", "C") {
+		t.Error("A -> C should not be available because Bridge->Cave is none")
+	}
+	// Sections missing from the response are not available
+	if line.isAvailable(schedule, "C", "D") {
+		t.Error("C -> D should not be available because it is missing")
+	}
+}
+
+func TestNewLineRejectsInvalidStations(t *testing.T) {
+	if _, err := newLine([]Station{{ID: "A", Name: "Arena"}}); err == nil {
+		t.Error("expected an error for a single station")
+	}
+	if _, err := newLine([]Station{{ID: "A", Name: "Arena"}, {ID: "A", Name: "Bridge"}}); err == nil {
+		t.Error("expected an error for duplicate IDs")
+	}
+}
@@ -3,6 +3,7 @@ package logger
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"sync"
@@ -25,8 +26,14 @@ var (
 	instance       Logger
 	once           sync.Once
 	globalLogLevel string
+	output         io.Writer = os.Stderr
 )
 
+// SetOutput sets the destination of the slog logger. It must be called before GetLogger.
+func SetOutput(w io.Writer) {
+	output = w
+}
+
 // GetLogger returns the singleton logger instance.
 func GetLogger(logLevel string) Logger {
 	once.Do(func() {
@@ -92,7 +99,7 @@ func NewSlogLogger() *SlogLogger {
 
 	logLevel := parseLogLevel(globalLogLevel)
 
-	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+	handler := slog.NewTextHandler(output, &slog.HandlerOptions{
 		Level: logLevel,
 		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
 			if a.Key == "time" && a.Value.Kind() == slog.KindTime {
@@ -1,24 +1,17 @@
 package bench
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"math/rand"
-	"net/http"
-	"os"
 	"runtime"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"golang.org/x/net/context"
-
 	"github.com/showwin/ISHOCON3/benchmark/bench/logger"
-
-	"github.com/isucon/isucandar/agent"
 )
 
 var jst = time.FixedZone("Asia/Tokyo", 9*60*60)
@@ -29,21 +22,42 @@ type NewUserCount struct {
 
 type Scenario struct {
 	targetURL               string
-	initializedAt           time.Time
+	clock                   *VirtualClock
+	clockMonitor            *clockMonitor
 	appLanguage             string
 	log                     logger.Logger
+	seed                    int64
+	profile                 *Profile
 	totalSales              *[32]atomic.Int64
 	totalRefunds            *[32]atomic.Int64
 	totalPurchased          *[32]atomic.Int64
 	totalTickets            *[32]atomic.Int64
 	refundWg                *sync.WaitGroup
-	criticalError           chan error
+	inFlightRefunds         *atomic.Int64
+	criticalErrors          *errorCollector
 	currentTicketPhaseIndex *atomic.Int32
 	currentSalesPhaseIndex  *atomic.Int32
 	addWorkersFn            func(ticketPhase, salesPhase int32)
-	purchasedReservations   *sync.Map // key: unique ID, value: "ScheduleID|Seat|FromTo" (e.g., "E2123|A-3|AD")
+	line                    *Line
+	seatIndex               *seatIndex
+	availability            *availabilityLedger
+	violations              *violationRecorder
+	sessions                *sessionTracker
+	waitingRoom             *waitingRoomMonitor
+	arrivals                *arrivalGenerator // nil in the closed load mode
+	connections             *connectionPool
+	requests                *requestRecorder
+	trainModels             *trainModelTable
+	ticketLedger            *sync.Map // key: reservation ID, value: *ticketRecord
 	ticketPhaseChans        []chan struct{}
 	salesPhaseChans         []chan struct{}
+	activeTicketWorkers     []atomic.Int64 // number of running workers per ticket phase
+	activeSalesWorkers      []atomic.Int64 // number of running workers per sales phase
+	validationUsers         *userPool
+	loadUsers               *userPool
+	adversary               *adversarySession
+	stage                   *atomic.Value // string, one of Stage*
+	warnings                *warningRecorder
 }
 
 // sumShardedCounter sums all 32 shards of a counter
@@ -60,30 +74,92 @@ type InitializeResponse struct {
 	AppLanguage   string    `json:"app_language"`
 }
 
-func Run(targetURL string, logLevel string) {
+// Run runs the benchmark against config.TargetURL and returns the result.
+// An error is returned only when the benchmark could not start.
+func Run(parentCtx context.Context, config Config) (*Result, error) {
 	// Limit to 4 CPU cores for benchmark consistency
 	runtime.GOMAXPROCS(4)
 
-	rand.New(rand.NewSource(time.Now().UnixNano())) // Seed random number generator
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
 
-	agent, err := agent.NewAgent(agent.WithBaseURL(targetURL), agent.WithTimeout(10*time.Second), agent.WithDefaultTransport())
+	profile := config.Profile
+	if profile == nil {
+		var err error
+		profile, err = DefaultProfile()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid profile: %w", err)
+	}
+	loadMode, err := ParseLoadMode(string(config.LoadMode))
 	if err != nil {
-		slog.Error("failed to create agent", "error", err.Error())
+		return nil, err
+	}
+	scorerName := config.Scorer
+	if scorerName == "" {
+		scorerName = profile.Scoring.Scorer
 	}
-	httpResp, err := HttpPost(context.Background(), agent, "/api/initialize", nil)
+	scorer, err := NewScorer(scorerName, profile.Scoring)
 	if err != nil {
-		slog.Error("failed to post /initialize", "error", err.Error())
+		return nil, err
+	}
+	if loadMode == LoadModeOpen && profile.TicketPhases.InitialArrivalRate+profile.SalesPhases.InitialArrivalRate == 0 {
+		return nil, fmt.Errorf("the open load mode needs initial_arrival_rate in ticket_phases or sales_phases of the profile")
+	}
+
+	users, err := loadUsersCSV()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load users: %w", err)
+	}
+	validationUsers, loadUsers := splitUserPools(users)
+	// The last validation user is the attacker of the adversary scenario, so no other session logs in as it
+	adversaryUser := validationUsers[len(validationUsers)-1]
+	validationUsers = validationUsers[:len(validationUsers)-1]
+
+	// Number of workers added per phase. Index 0 is active from the start.
+	ticketPhaseWorkerCounts := profile.TicketPhases.workerCounts()
+	salesPhaseWorkerCounts := profile.SalesPhases.workerCounts()
+
+	result := &Result{
+		TicketPhaseCount: len(profile.TicketPhases.Phases),
+		SalesPhaseCount:  len(profile.SalesPhases.Phases),
+		Seed:             seed,
+		LoadMode:         loadMode,
+		Scorer:           scorer.Name(),
+		StartedAt:        time.Now(),
+	}
+	targetURL := config.TargetURL
+
+	requests := newRequestRecorder()
+	connections := newConnectionPool(profile.HTTP, requests)
+	agent, err := connections.newAgent(targetURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create agent: %w", err)
+	}
+	httpResp, err := HttpPost(parentCtx, agent, "/api/initialize", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to post /api/initialize: %w", err)
+	}
+	if httpResp.StatusCode != 200 {
+		return nil, fmt.Errorf("initialize returned %d status: %s", httpResp.StatusCode, string(httpResp.Body))
 	}
 	var initResp InitializeResponse
 	if err := json.Unmarshal(httpResp.Body, &initResp); err != nil {
-		slog.Error("failed to unmarshal response", "error", err.Error())
+		return nil, fmt.Errorf("failed to unmarshal /api/initialize response: %w", err)
 	}
-	if httpResp.StatusCode != 200 {
-		slog.Error("initialize returned non-200 status", "status_code", httpResp.StatusCode, "body", string(httpResp.Body))
-		os.Exit(1)
+	result.AppLanguage = initResp.AppLanguage
+
+	line, err := fetchLine(parentCtx, agent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the line topology: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(parentCtx, profile.Duration)
 	defer cancel()
 
 	// Initialize sharded atomic counters to reduce contention
@@ -94,195 +170,278 @@ func Run(targetURL string, logLevel string) {
 	var currentTicketPhaseIndex atomic.Int32
 	var currentSalesPhaseIndex atomic.Int32
 	var refundWg sync.WaitGroup
-	criticalError := make(chan error, 1) // Buffered channel to prevent blocking
-	var purchasedReservations sync.Map   // Stores "ScheduleID|Seat|FromTo" strings
+	var inFlightRefunds atomic.Int64
+	var ticketLedger sync.Map // Stores *ticketRecord per reservation ID
 
 	// Phase channels for controlling pre-spawned workers (much faster than flag polling)
-	ticketPhaseChans := make([]chan struct{}, 6)
-	ticketPhaseOnce := make([]sync.Once, 6)
+	ticketPhaseChans := make([]chan struct{}, len(ticketPhaseWorkerCounts))
+	ticketPhaseOnce := make([]sync.Once, len(ticketPhaseWorkerCounts))
 	for i := range ticketPhaseChans {
 		ticketPhaseChans[i] = make(chan struct{})
 	}
-	salesPhaseChans := make([]chan struct{}, 8)
-	salesPhaseOnce := make([]sync.Once, 8)
+	salesPhaseChans := make([]chan struct{}, len(salesPhaseWorkerCounts))
+	salesPhaseOnce := make([]sync.Once, len(salesPhaseWorkerCounts))
 	for i := range salesPhaseChans {
 		salesPhaseChans[i] = make(chan struct{})
 	}
 
-	log := logger.GetLogger(logLevel)
+	var arrivals *arrivalGenerator
+	if loadMode == LoadModeOpen {
+		arrivals = newArrivalGenerator(profile)
+	}
+
+	// The application clock starts at 00:00 when the application is initialized
+	clock := NewVirtualClock(initResp.InitializedAt, profile.Clock.Speed)
+
+	warnings := &warningRecorder{}
+	log := &warningLogger{Logger: logger.GetLogger(config.LogLevel), warnings: warnings}
+	var stage atomic.Value
+	stage.Store(StagePreValidation)
 	scenario := Scenario{
 		targetURL:               targetURL,
-		initializedAt:           initResp.InitializedAt,
+		clock:                   clock,
+		clockMonitor:            newClockMonitor(clock, profile.Clock.DriftTolerance),
 		appLanguage:             initResp.AppLanguage,
 		log:                     log,
+		seed:                    seed,
+		profile:                 profile,
 		totalSales:              &totalSales,
 		totalRefunds:            &totalRefunds,
 		totalPurchased:          &totalPurchased,
 		totalTickets:            &totalTickets,
 		refundWg:                &refundWg,
-		criticalError:           criticalError,
+		inFlightRefunds:         &inFlightRefunds,
+		criticalErrors:          newErrorCollector(log, cancel),
 		currentTicketPhaseIndex: &currentTicketPhaseIndex,
 		currentSalesPhaseIndex:  &currentSalesPhaseIndex,
-		purchasedReservations:   &purchasedReservations,
+		line:                    line,
+		seatIndex:               newSeatIndex(line),
+		availability:            newAvailabilityLedger(line),
+		violations:              &violationRecorder{},
+		sessions:                newSessionTracker(profile.WaitingRoom.MaxActiveUsers),
+		waitingRoom:             newWaitingRoomMonitor(profile.WaitingRoom),
+		arrivals:                arrivals,
+		connections:             connections,
+		requests:                requests,
+		ticketLedger:            &ticketLedger,
 		ticketPhaseChans:        ticketPhaseChans,
 		salesPhaseChans:         salesPhaseChans,
+		activeTicketWorkers:     make([]atomic.Int64, len(ticketPhaseWorkerCounts)),
+		activeSalesWorkers:      make([]atomic.Int64, len(salesPhaseWorkerCounts)),
+		validationUsers:         newUserPool(validationUsers, config.UniqueUsers),
+		loadUsers:               newUserPool(loadUsers, config.UniqueUsers),
+		adversary:               newAdversarySession(adversaryUser),
+		stage:                   &stage,
+		warnings:                warnings,
 	}
 
-	currentTimeStr := getApplicationClock(scenario.initializedAt)
-	slog.Info("Benchmark Start!", "current_time", currentTimeStr)
+	trainModels, err := scenario.getTrainModels(parentCtx, agent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the train models: %w", err)
+	}
+	var unknownModels []string
+	scenario.trainModels, unknownModels = newTrainModelTable(trainModels.ModelNames)
+	if len(unknownModels) > 0 {
+		slog.Warn("Seat layouts of some train models are unknown. Their availability labels are not validated.", "models", unknownModels)
+	}
+
+	if config.MetricsAddr != "" {
+		metricsServer, err := scenario.startMetricsServer(config.MetricsAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start metrics server: %w", err)
+		}
+		defer metricsServer.Close()
+		slog.Info("Serving metrics", "addr", config.MetricsAddr)
+	}
+
+	if config.OnProgress != nil {
+		progressCtx, progressCancel := context.WithCancel(parentCtx)
+		defer progressCancel()
+		go scenario.reportProgress(progressCtx, result.StartedAt, config.OnProgress)
+	}
+
+	currentTimeStr := scenario.clock.Now()
+	slog.Info("Benchmark Start!", "current_time", currentTimeStr, "seed", seed)
+
+	// Check the application works correctly before putting load on it
+	if err := scenario.runPreValidation(ctx); err != nil {
+		slog.Error("Pre-validation failed, stopping benchmark", "error", err.Error())
+		warnings.add(err.Error())
+		result.CriticalError = err.Error()
+		result.CriticalErrors = []CriticalError{{Category: ErrorCategoryPreValidation, Message: err.Error(), At: time.Now()}}
+		result.Violations = []Violation{{Kind: ViolationPreValidation, Message: err.Error()}}
+		result.ApplicationTime = scenario.clock.Now()
+		result.Endpoints = requests.summary()
+		result.ScoreBreakdown = scorer.Score(ScoreInput{Violations: result.Violations, Endpoints: result.Endpoints}).Terms
+		result.Connections = connections.stats()
+		result.FinishedAt = time.Now()
+		return result, nil
+	}
+
+	scenario.setStage(StageLoad)
 
 	// Start admin scenario
 	go scenario.RunAdminScenario(ctx)
 
-	// Define worker counts per ticket phase.
-	// <tickets> => <added workers>
-	// 0 => 5
-	// 5 => +5
-	// 10 => +10
-	// 50 => +20
-	// 100 => +20
-	// 200 => +20
-	ticketPhaseWorkerCounts := []int{5, 5, 10, 20, 20, 20}
-
-	// Define worker counts per sales phase.
-	// <sales> => <added workers>
-	// 0 => 10
-	// 1000 => +5
-	// 3000 => +5
-	// 10000 => +5
-	// 50000 => +20
-	// 200000 => +50
-	// 500000 => +100
-	// 1000000 => +100
-	salesPhaseWorkerCounts := []int{15, 5, 5, 5, 20, 50, 100, 100}
-
-	// Calculate total workers needed
-	totalTicketWorkers := 0
-	for _, count := range ticketPhaseWorkerCounts {
-		totalTicketWorkers += count
-	}
-	totalSalesWorkers := 0
-	for _, count := range salesPhaseWorkerCounts {
-		totalSalesWorkers += count
-	}
-	totalWorkers := totalTicketWorkers + totalSalesWorkers
-
-	// Pre-spawn all workers as goroutines
+	// Start adversary scenario trying requests the application must reject
+	go scenario.RunAdversaryScenario(ctx)
+
+	// Check the application clock does not drift from the one of the benchmark
+	go scenario.RunClockScenario(ctx)
+
 	workerDone := make(chan struct{})
 	var workersWg sync.WaitGroup
-	workersWg.Add(totalWorkers)
-
-	// Spawn ticket phase workers
-	workerIdx := 0
-	for phaseIdx, count := range ticketPhaseWorkerCounts {
-		for i := 0; i < count; i++ {
-			go func(phase int, phaseChan chan struct{}) {
-				defer workersWg.Done()
-				// Wait until this phase is active (blocks with zero CPU until channel is closed)
-				select {
-				case <-phaseChan:
-					// Phase activated
-				case <-ctx.Done():
-					return
-				}
-				// Run user scenario in a loop until context is done
-				for {
+	if arrivals := scenario.arrivals; arrivals != nil {
+		// Start users at the arrival rate of the reached phases
+		scenario.addWorkersFn = func(newTicketPhase, newSalesPhase int32) {
+			oldRate := arrivals.rate()
+			arrivals.setPhases(newTicketPhase, newSalesPhase)
+			if newRate := arrivals.rate(); newRate > oldRate {
+				log.Info("New ad campaign launched!",
+					"ticket_phase", fmt.Sprintf("%d/%d", newTicketPhase, len(profile.TicketPhases.Phases)),
+					"sales_phase", fmt.Sprintf("%d/%d", newSalesPhase, len(profile.SalesPhases.Phases)),
+					"arrival_rate", newRate,
+					"current_time", scenario.clock.Now(),
+					"user", "admin",
+				)
+			}
+		}
+		workersWg.Add(1)
+		go func() {
+			defer workersWg.Done()
+			arrivals.run(ctx, scenario.newRand(streamArrivals), scenario.RunUserScenario)
+		}()
+	} else {
+		// Calculate total workers needed
+		totalTicketWorkers := 0
+		for _, count := range ticketPhaseWorkerCounts {
+			totalTicketWorkers += count
+		}
+		totalSalesWorkers := 0
+		for _, count := range salesPhaseWorkerCounts {
+			totalSalesWorkers += count
+		}
+		totalWorkers := totalTicketWorkers + totalSalesWorkers
+
+		// Pre-spawn all workers as goroutines
+		workersWg.Add(totalWorkers)
+
+		// Spawn ticket phase workers
+		workerIdx := 0
+		for phaseIdx, count := range ticketPhaseWorkerCounts {
+			for i := 0; i < count; i++ {
+				go func(phase int, phaseChan chan struct{}, rng *rand.Rand) {
+					defer workersWg.Done()
+					// Wait until this phase is active (blocks with zero CPU until channel is closed)
 					select {
+					case <-phaseChan:
+						// Phase activated
 					case <-ctx.Done():
 						return
-					default:
-						scenario.RunUserScenario(ctx)
 					}
-				}
-			}(phaseIdx, ticketPhaseChans[phaseIdx])
-			workerIdx++
+					scenario.activeTicketWorkers[phase].Add(1)
+					defer scenario.activeTicketWorkers[phase].Add(-1)
+					// Run user scenario in a loop until context is done
+					for {
+						select {
+						case <-ctx.Done():
+							return
+						default:
+							scenario.RunUserScenario(ctx, rng)
+						}
+					}
+				}(phaseIdx, ticketPhaseChans[phaseIdx], scenario.newRand(streamUserWorkers+int64(workerIdx)))
+				workerIdx++
+			}
 		}
-	}
 
-	// Spawn sales phase workers
-	for phaseIdx, count := range salesPhaseWorkerCounts {
-		for i := 0; i < count; i++ {
-			go func(phase int, phaseChan chan struct{}) {
-				defer workersWg.Done()
-				// Wait until this phase is active (blocks with zero CPU until channel is closed)
-				select {
-				case <-phaseChan:
-					// Phase activated
-				case <-ctx.Done():
-					return
-				}
-				// Run user scenario in a loop until context is done
-				for {
+		// Spawn sales phase workers
+		for phaseIdx, count := range salesPhaseWorkerCounts {
+			for i := 0; i < count; i++ {
+				go func(phase int, phaseChan chan struct{}, rng *rand.Rand) {
+					defer workersWg.Done()
+					// Wait until this phase is active (blocks with zero CPU until channel is closed)
 					select {
+					case <-phaseChan:
+						// Phase activated
 					case <-ctx.Done():
 						return
-					default:
-						scenario.RunUserScenario(ctx)
 					}
-				}
-			}(phaseIdx, salesPhaseChans[phaseIdx])
-			workerIdx++
+					scenario.activeSalesWorkers[phase].Add(1)
+					defer scenario.activeSalesWorkers[phase].Add(-1)
+					// Run user scenario in a loop until context is done
+					for {
+						select {
+						case <-ctx.Done():
+							return
+						default:
+							scenario.RunUserScenario(ctx, rng)
+						}
+					}
+				}(phaseIdx, salesPhaseChans[phaseIdx], scenario.newRand(streamUserWorkers+int64(workerIdx)))
+				workerIdx++
+			}
 		}
-	}
 
-	// Function to activate phase flags
-	var lastTicketPhase atomic.Int32
-	lastTicketPhase.Store(-1)
-	var lastSalesPhase atomic.Int32
-	lastSalesPhase.Store(-1)
-
-	addWorkersFn := func(newTicketPhase, newSalesPhase int32) {
-		oldTicketPhase := lastTicketPhase.Load()
-		oldSalesPhase := lastSalesPhase.Load()
-
-		// Handle ticket phase change - close channels to activate workers (only once per channel)
-		if newTicketPhase > oldTicketPhase {
-			for phase := oldTicketPhase + 1; phase <= newTicketPhase; phase++ {
-				p := phase // Capture for closure
-				ticketPhaseOnce[p].Do(func() {
-					close(ticketPhaseChans[p])
-					addedWorkers := ticketPhaseWorkerCounts[p]
-					currentTimeStr := getApplicationClock(scenario.initializedAt)
-					log.Info("New ad campaign launched!",
-						"ticket_phase", fmt.Sprintf("%d/%d", p, len(ticketSoldPhases)),
-						"new_buyers", addedWorkers,
-						"current_time", currentTimeStr,
-						"user", "admin",
-					)
-				})
+		// Function to activate phase flags
+		var lastTicketPhase atomic.Int32
+		lastTicketPhase.Store(-1)
+		var lastSalesPhase atomic.Int32
+		lastSalesPhase.Store(-1)
+
+		addWorkersFn := func(newTicketPhase, newSalesPhase int32) {
+			oldTicketPhase := lastTicketPhase.Load()
+			oldSalesPhase := lastSalesPhase.Load()
+
+			// Handle ticket phase change - close channels to activate workers (only once per channel)
+			if newTicketPhase > oldTicketPhase {
+				for phase := oldTicketPhase + 1; phase <= newTicketPhase; phase++ {
+					p := phase // Capture for closure
+					ticketPhaseOnce[p].Do(func() {
+						close(ticketPhaseChans[p])
+						addedWorkers := ticketPhaseWorkerCounts[p]
+						currentTimeStr := scenario.clock.Now()
+						log.Info("New ad campaign launched!",
+							"ticket_phase", fmt.Sprintf("%d/%d", p, len(profile.TicketPhases.Phases)),
+							"new_buyers", addedWorkers,
+							"current_time", currentTimeStr,
+							"user", "admin",
+						)
+					})
+				}
+				lastTicketPhase.Store(newTicketPhase)
 			}
-			lastTicketPhase.Store(newTicketPhase)
-		}
 
-		// Handle sales phase change - close channels to activate workers (only once per channel)
-		if newSalesPhase > oldSalesPhase {
-			for phase := oldSalesPhase + 1; phase <= newSalesPhase; phase++ {
-				p := phase // Capture for closure
-				salesPhaseOnce[p].Do(func() {
-					close(salesPhaseChans[p])
-					addedWorkers := salesPhaseWorkerCounts[p]
-					currentTimeStr := getApplicationClock(scenario.initializedAt)
-					log.Info("New ad campaign launched!",
-						"sales_phase", fmt.Sprintf("%d/%d", p, len(salesPhases)),
-						"new_buyers", addedWorkers,
-						"current_time", currentTimeStr,
-						"user", "admin",
-					)
-				})
+			// Handle sales phase change - close channels to activate workers (only once per channel)
+			if newSalesPhase > oldSalesPhase {
+				for phase := oldSalesPhase + 1; phase <= newSalesPhase; phase++ {
+					p := phase // Capture for closure
+					salesPhaseOnce[p].Do(func() {
+						close(salesPhaseChans[p])
+						addedWorkers := salesPhaseWorkerCounts[p]
+						currentTimeStr := scenario.clock.Now()
+						log.Info("New ad campaign launched!",
+							"sales_phase", fmt.Sprintf("%d/%d", p, len(profile.SalesPhases.Phases)),
+							"new_buyers", addedWorkers,
+							"current_time", currentTimeStr,
+							"user", "admin",
+						)
+					})
+				}
+				lastSalesPhase.Store(newSalesPhase)
 			}
-			lastSalesPhase.Store(newSalesPhase)
 		}
-	}
 
-	scenario.addWorkersFn = addWorkersFn
+		scenario.addWorkersFn = addWorkersFn
 
-	// Activate initial phases (phase 0) by closing channels (using Once to prevent double-close)
-	ticketPhaseOnce[0].Do(func() {
-		close(ticketPhaseChans[0])
-	})
-	salesPhaseOnce[0].Do(func() {
-		close(salesPhaseChans[0])
-	})
+		// Activate initial phases (phase 0) by closing channels (using Once to prevent double-close)
+		ticketPhaseOnce[0].Do(func() {
+			close(ticketPhaseChans[0])
+		})
+		salesPhaseOnce[0].Do(func() {
+			close(salesPhaseChans[0])
+		})
+	}
 
 	// Monitor for workers completion
 	go func() {
@@ -291,28 +450,24 @@ func Run(targetURL string, logLevel string) {
 	}()
 
 	// Wait for either worker completion or critical error
-	var criticalErrorMessage string
 	select {
 	case <-workerDone:
 		// Normal completion
-	case critErr := <-criticalError:
-		// Critical error occurred, cancel context and stop benchmark
-		criticalErrorMessage = critErr.Error()
-		slog.Error("Critical error occurred, stopping benchmark", "error", criticalErrorMessage)
-		cancel()
-		// Wait a bit for goroutines to clean up
+	case <-scenario.criticalErrors.done():
+		// The first critical error cancelled the context. Wait a bit for goroutines to clean up
 		<-workerDone
 	}
 
-	currentTimeStr = getApplicationClock(scenario.initializedAt)
+	currentTimeStr = scenario.clock.Now()
 	finalSales := sumShardedCounter(&totalSales)
 	finalPurchased := sumShardedCounter(&totalPurchased)
 	finalTickets := sumShardedCounter(&totalTickets)
 	finalTicketPhase := currentTicketPhaseIndex.Load()
 	finalSalesPhase := currentSalesPhaseIndex.Load()
-	fmt.Printf("\nMain phase finished. Waiting for pending refunds to complete... (current_time: %s)\n", currentTimeStr)
+	slog.Info("Main phase finished. Waiting for pending refunds to complete...", "current_time", currentTimeStr)
+	scenario.setStage(StageRefunds)
 
-	// Wait for all refund operations to complete with 10 second timeout (but don't treat timeout as error)
+	// Wait for all refund operations to complete within the grace period (but don't treat timeout as error)
 	refundDone := make(chan struct{})
 	go func() {
 		refundWg.Wait()
@@ -322,173 +477,65 @@ func Run(targetURL string, logLevel string) {
 	select {
 	case <-refundDone:
 		// Refunds completed successfully
-	case <-time.After(10 * time.Second):
+	case <-time.After(profile.RefundGracePeriod):
 		// Timeout - just continue (not a critical error)
-		// TODO: Fix this. For some reason, refunds sometimes hang indefinitely.
 	}
 
-	// Check if there was a critical error during refund phase
-	select {
-	case critErr := <-criticalError:
-		criticalErrorMessage = critErr.Error()
-		slog.Error("Critical error occurred, stopping benchmark", "error", criticalErrorMessage)
-		cancel()
-	default:
-		// No critical error, proceed with final score
+	// Critical errors may also be reported during the refund phase
+	criticalErrors := scenario.criticalErrors.all()
+	var criticalErrorMessage string
+	if len(criticalErrors) > 0 {
+		criticalErrorMessage = criticalErrors[0].Message
 	}
 
 	finalRefunds := sumShardedCounter(&totalRefunds)
-	score := int64((float64(finalSales) + float64(finalPurchased-finalSales)*0.5 - float64(finalRefunds)) / 100)
-
-	time.Sleep(3 * time.Second) // Wait for slog to flush
-
-	// Validate no double booking per section
-	// Convert "ScheduleID|Seat|FromTo" to individual sections "ScheduleID|Seat|AB", "ScheduleID|Seat|BC", etc.
-	sectionReservations := make(map[string]bool)
-	duplicateFound := false
-	duplicateSchedule := ""
-	duplicateSeat := ""
-	duplicateSection := ""
-
-	scenario.purchasedReservations.Range(func(key, value interface{}) bool {
-		reservation := value.(string) // e.g., "E2123|A-3|AD"
-		parts := splitReservation(reservation)
-		scheduleID := parts[0]
-		seat := parts[1]
-		fromTo := parts[2]
-
-		// Expand to individual sections
-		sections := expandToSections(fromTo)
-		for _, section := range sections {
-			sectionKey := scheduleID + "|" + seat + "|" + section
-			if sectionReservations[sectionKey] {
-				slog.Error("Double booking detected!", "section_key", sectionKey, "original_reservation", reservation)
-				duplicateFound = true
-				duplicateSchedule = scheduleID
-				duplicateSeat = seat
-				duplicateSection = section
-				return false // Stop iteration
-			}
-			sectionReservations[sectionKey] = true
-		}
-		return true
-	})
-
-	if criticalErrorMessage == "" && duplicateFound {
-		slog.Error("Double booking validation failed!")
-		criticalErrorMessage = fmt.Sprintf("Double booking detected: Schedule %s, Seat %s, Section %s", duplicateSchedule, duplicateSeat, duplicateSection)
-		score = 0
-	}
 
-	// Always output final results regardless of log level
-	fmt.Println("\nBenchmark Finished!")
-	if criticalErrorMessage != "" {
-		fmt.Println("  Interrupted due to critical error:")
-		fmt.Printf("  %s\n\n", criticalErrorMessage)
-	}
-
-	fmt.Printf("  Score: %d\n", score)
-	fmt.Printf("  Total Sales: %d\n", finalSales)
-	fmt.Printf("  Total Purchased: %d\n", finalPurchased)
-	fmt.Printf("  Total Refunds: %d\n", finalRefunds)
-	fmt.Printf("  Net Revenue: %d\n", finalSales-finalRefunds)
-	fmt.Printf("  Total Tickets: %d\n", finalTickets)
-	fmt.Printf("  Ticket Phase: %d/%d\n", finalTicketPhase, len(ticketSoldPhases))
-	fmt.Printf("  Sales Phase: %d/%d\n", finalSalesPhase, len(salesPhases))
-	fmt.Printf("  Current Time: %s\n\n", currentTimeStr)
-
-	postScore(score, scenario.appLanguage)
-}
-
-// splitReservation splits "ScheduleID|Seat|FromTo" into parts
-func splitReservation(reservation string) []string {
-	return strings.Split(reservation, "|")
-}
-
-// expandToSections expands "AD" to ["AB", "BC", "CD"]
-func expandToSections(fromTo string) []string {
-	if len(fromTo) != 2 {
-		return []string{}
-	}
-
-	from := fromTo[0]
-	to := fromTo[1]
-
-	// Station order: A, B, C, D, E
-	stations := "ABCDE"
-	fromIdx := strings.IndexByte(stations, from)
-	toIdx := strings.IndexByte(stations, to)
-
-	if fromIdx == -1 || toIdx == -1 {
-		return []string{}
-	}
-
-	// Handle both directions
-	sections := []string{}
-	if fromIdx < toIdx {
-		// Forward direction (e.g., A->D becomes AB, BC, CD)
-		for i := fromIdx; i < toIdx; i++ {
-			sections = append(sections, string(stations[i])+string(stations[i+1]))
-		}
-	} else {
-		// Reverse direction (e.g., D->A becomes DC, CB, BA)
-		for i := fromIdx; i > toIdx; i-- {
-			sections = append(sections, string(stations[i])+string(stations[i-1]))
+	// Validate the application state is consistent with what the benchmark did
+	slog.Info("Post-validation started")
+	scenario.setStage(StagePostValidation)
+	var violations []Violation
+	for _, e := range criticalErrors {
+		if e.Category == ErrorCategoryPreValidation {
+			violations = append(violations, Violation{Kind: ViolationPreValidation, Message: e.Message})
 		}
 	}
-
-	return sections
-}
-
-func postScore(score int64, appLanguage string) {
-	apiURL := os.Getenv("BENCH_SCOREBOARD_APIGW_URL")
-	teamName := os.Getenv("BENCH_TEAM_NAME")
-	if apiURL == "" && teamName == "" {
-		return
-	}
-
-	location, err := time.LoadLocation("Asia/Tokyo")
-	if err != nil {
-		slog.Error("Failed to send score")
-		slog.Error("Error loading location.", "error", err.Error())
-		return
-	}
-	now := time.Now().In(location)
-	timestamp := now.Format(time.RFC3339)
-
-	data := map[string]interface{}{
-		"team":      teamName,
-		"score":     score,
-		"timestamp": timestamp,
-		"language":  appLanguage,
+	violations = append(violations, scenario.runPostValidation(context.Background())...)
+	for _, v := range violations {
+		slog.Error("Post-validation violation", "kind", v.Kind, "message", v.Message)
+		warnings.add(v.Message)
 	}
-
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		slog.Error("Failed to send score")
-		slog.Error("Error encoding JSON.", "error", err.Error())
-		return
+	if failedViolation, _ := summarizeViolations(violations); failedViolation != nil && criticalErrorMessage == "" {
+		criticalErrorMessage = failedViolation.Message
 	}
+	endpoints := requests.summary()
+	score := scorer.Score(ScoreInput{
+		Sales:      finalSales,
+		Purchased:  finalPurchased,
+		Refunds:    finalRefunds,
+		Violations: violations,
+		Endpoints:  endpoints,
+	})
 
-	// Create the PUT request
-	req, err := http.NewRequest("PUT", apiURL+"teams", bytes.NewBuffer(jsonData))
-	if err != nil {
-		slog.Error("Failed to send score")
-		slog.Error("Error creating request.", "error", err.Error())
-		return
+	result.Score = score.Score
+	result.ScoreBreakdown = score.Terms
+	result.TotalSales = finalSales
+	result.TotalPurchased = finalPurchased
+	result.TotalRefunds = finalRefunds
+	result.TotalTickets = finalTickets
+	result.TicketPhase = int(finalTicketPhase)
+	result.SalesPhase = int(finalSalesPhase)
+	result.CriticalError = criticalErrorMessage
+	result.CriticalErrors = criticalErrors
+	result.Violations = violations
+	result.ApplicationTime = currentTimeStr
+	result.Endpoints = endpoints
+	result.WaitingRoom = scenario.waitingRoom.stats()
+	result.Connections = connections.stats()
+	result.Clock = scenario.clockMonitor.snapshot()
+	if scenario.arrivals != nil {
+		result.Arrivals = scenario.arrivals.stats()
 	}
+	result.FinishedAt = time.Now()
 
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-
-	// Send the request using the http.DefaultClient
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		slog.Error("Failed to send score")
-		slog.Error("Error sending request.", "error", err.Error())
-		return
-	}
-	defer resp.Body.Close()
-	slog.Info("Score sent to scoreboard")
+	return result, nil
 }
@@ -0,0 +1,26 @@
+package bench
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRunReturnsErrorWhenInitializeFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/initialize" {
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	result, err := Run(context.Background(), Config{TargetURL: server.URL, LogLevel: "error"})
+	if err == nil {
+		t.Fatalf("Expected an error, got result %+v", result)
+	}
+	if result != nil {
+		t.Errorf("Expected no result, got %+v", result)
+	}
+}
@@ -0,0 +1,120 @@
+package bench
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "ishocon3_bench"
+
+// Every n-th latency bucket is exported, so the exported buckets grow about 2x.
+const metricsLatencyBucketStep = 8
+
+// metricsCollector exposes the state of a running scenario as Prometheus metrics.
+// Values are read on every scrape, so the scenario does not need to update them.
+type metricsCollector struct {
+	scenario *Scenario
+
+	totalSales      *prometheus.Desc
+	totalPurchased  *prometheus.Desc
+	totalRefunds    *prometheus.Desc
+	totalTickets    *prometheus.Desc
+	phase           *prometheus.Desc
+	activeWorkers   *prometheus.Desc
+	inFlightRefunds *prometheus.Desc
+	requests        *prometheus.Desc
+	requestDuration *prometheus.Desc
+}
+
+func newMetricsCollector(scenario *Scenario) *metricsCollector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", name), help, labels, nil)
+	}
+	return &metricsCollector{
+		scenario:        scenario,
+		totalSales:      desc("sales_yen_total", "Sales of the entered tickets."),
+		totalPurchased:  desc("purchased_yen_total", "Price of the purchased tickets."),
+		totalRefunds:    desc("refunds_yen_total", "Refunded amount."),
+		totalTickets:    desc("tickets", "Number of purchased tickets not refunded."),
+		phase:           desc("phase", "Index of the current phase.", "type"),
+		activeWorkers:   desc("active_workers", "Number of buyers running per phase.", "type", "phase"),
+		inFlightRefunds: desc("in_flight_refunds", "Number of refunds in progress."),
+		requests:        desc("http_requests_total", "Number of requests per route and status.", "method", "route", "status"),
+		requestDuration: desc("http_request_duration_seconds", "Latency of the responses per route and status.", "method", "route", "status"),
+	}
+}
+
+func (c *metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.scenario
+	ch <- prometheus.MustNewConstMetric(c.totalSales, prometheus.CounterValue, float64(sumShardedCounter(s.totalSales)))
+	ch <- prometheus.MustNewConstMetric(c.totalPurchased, prometheus.CounterValue, float64(sumShardedCounter(s.totalPurchased)))
+	ch <- prometheus.MustNewConstMetric(c.totalRefunds, prometheus.CounterValue, float64(sumShardedCounter(s.totalRefunds)))
+	ch <- prometheus.MustNewConstMetric(c.totalTickets, prometheus.GaugeValue, float64(sumShardedCounter(s.totalTickets)))
+	ch <- prometheus.MustNewConstMetric(c.phase, prometheus.GaugeValue, float64(s.currentTicketPhaseIndex.Load()), "ticket")
+	ch <- prometheus.MustNewConstMetric(c.phase, prometheus.GaugeValue, float64(s.currentSalesPhaseIndex.Load()), "sales")
+	for i := range s.activeTicketWorkers {
+		ch <- prometheus.MustNewConstMetric(c.activeWorkers, prometheus.GaugeValue, float64(s.activeTicketWorkers[i].Load()), "ticket", strconv.Itoa(i))
+	}
+	for i := range s.activeSalesWorkers {
+		ch <- prometheus.MustNewConstMetric(c.activeWorkers, prometheus.GaugeValue, float64(s.activeSalesWorkers[i].Load()), "sales", strconv.Itoa(i))
+	}
+	ch <- prometheus.MustNewConstMetric(c.inFlightRefunds, prometheus.GaugeValue, float64(s.inFlightRefunds.Load()))
+
+	s.requests.mu.Lock()
+	defer s.requests.mu.Unlock()
+	for key, stats := range s.requests.routes {
+		for statusCode, histogram := range stats.byStatus {
+			status := strconv.Itoa(statusCode)
+			ch <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(histogram.count), key.method, key.route, status)
+			ch <- prometheus.MustNewConstHistogram(c.requestDuration, uint64(histogram.count), histogram.sum.Seconds(),
+				histogram.cumulativeBuckets(metricsLatencyBucketStep), key.method, key.route, status)
+		}
+		ch <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(stats.timeouts), key.method, key.route, "timeout")
+		ch <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(stats.connectionErrors), key.method, key.route, "connection_error")
+	}
+}
+
+// cumulativeBuckets returns the number of observations below the upper bound of every step-th bucket in seconds.
+func (h *latencyHistogram) cumulativeBuckets(step int) map[float64]uint64 {
+	buckets := make(map[float64]uint64, latencyBucketCount/step+1)
+	var cumulative int64
+	for i, count := range h.buckets {
+		cumulative += count
+		if i%step == 0 {
+			buckets[latencyBucketUpperBound(i).Seconds()] = uint64(cumulative)
+		}
+	}
+	return buckets
+}
+
+// startMetricsServer serves the metrics of the scenario on addr in the background.
+// The caller must shut down the returned server.
+func (s *Scenario) startMetricsServer(addr string) (*http.Server, error) {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(newMetricsCollector(s)); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Error("Metrics server stopped", "error", err.Error())
+		}
+	}()
+	return server, nil
+}
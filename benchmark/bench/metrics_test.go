@@ -0,0 +1,60 @@
+package bench
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/showwin/ISHOCON3/benchmark/bench/logger"
+)
+
+func TestMetricsServer(t *testing.T) {
+	var totalSales, totalPurchased, totalRefunds, totalTickets [32]atomic.Int64
+	var ticketPhase, salesPhase atomic.Int32
+	var inFlightRefunds atomic.Int64
+	s := &Scenario{
+		log:                     logger.GetLogger("error"),
+		totalSales:              &totalSales,
+		totalPurchased:          &totalPurchased,
+		totalRefunds:            &totalRefunds,
+		totalTickets:            &totalTickets,
+		currentTicketPhaseIndex: &ticketPhase,
+		currentSalesPhaseIndex:  &salesPhase,
+		inFlightRefunds:         &inFlightRefunds,
+		activeTicketWorkers:     make([]atomic.Int64, 2),
+		activeSalesWorkers:      make([]atomic.Int64, 2),
+		requests:                newRequestRecorder(),
+	}
+	totalSales[3].Add(1000)
+	totalSales[7].Add(2000)
+	salesPhase.Store(1)
+	s.activeSalesWorkers[1].Add(5)
+	inFlightRefunds.Add(2)
+
+	s.requests.recordResponse("GET", "/api/qr/abc.png", 200, 15*time.Millisecond)
+
+	server, err := s.startMetricsServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start metrics server: %v", err)
+	}
+	defer server.Close()
+
+	// startMetricsServer does not expose the listener address, so serve the handler directly
+	recorder := httptest.NewRecorder()
+	server.Handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	for _, want := range []string{
+		"ishocon3_bench_sales_yen_total 3000",
+		`ishocon3_bench_phase{type="sales"} 1`,
+		`ishocon3_bench_active_workers{phase="1",type="sales"} 5`,
+		"ishocon3_bench_in_flight_refunds 2",
+		`ishocon3_bench_http_requests_total{method="GET",route="/api/qr/{id}.png",status="200"} 1`,
+		`ishocon3_bench_http_request_duration_seconds_count{method="GET",route="/api/qr/{id}.png",status="200"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package bench
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Fare of a seat for a section between adjacent stations. It is the same for every train model.
+const baseFarePerSection = 1000
+
+// Discount in percent for a group whose seats are scattered
+const scatteredSeatsDiscountPercent = 50
+
+// Columns of a seat row from the window side (e.g., "3-B" is the second seat of the 3rd row)
+const seatColumnLetters = "ABCDE"
+
+// priceQuote is the price the application should show for a reservation.
+type priceQuote struct {
+	TotalPrice   int
+	IsDiscounted bool
+}
+
+// quotePrice returns the price of the seats of a train of the model from `from` to `to`.
+// A group of seats is discounted if it spans more rows than necessary or if its seats in a row are not adjacent.
+// A single seat is never discounted.
+func quotePrice(line *Line, model trainModel, from, to string, seats []string) (priceQuote, error) {
+	if line.stationName(from) == "" || line.stationName(to) == "" || from == to {
+		return priceQuote{}, fmt.Errorf("invalid stations: %s -> %s", from, to)
+	}
+	if len(seats) == 0 {
+		return priceQuote{}, fmt.Errorf("no seats")
+	}
+
+	farePerSeat := baseFarePerSection * line.fareDistance(from, to)
+	fullPrice := farePerSeat * len(seats)
+	if len(seats) == 1 {
+		return priceQuote{TotalPrice: fullPrice}, nil
+	}
+
+	scattered, err := isScattered(seats, model.SeatColumns)
+	if err != nil {
+		return priceQuote{}, err
+	}
+	if scattered {
+		return priceQuote{TotalPrice: fullPrice * (100 - scatteredSeatsDiscountPercent) / 100, IsDiscounted: true}, nil
+	}
+	return priceQuote{TotalPrice: fullPrice}, nil
+}
+
+// isScattered reports whether seats span more rows than a train with `columns` seats per row needs,
+// or whether any of them are not next to each other in a row.
+func isScattered(seats []string, columns int) (bool, error) {
+	columnsByRow := make(map[int][]int)
+	for _, seat := range seats {
+		row, column, err := parseSeat(seat)
+		if err != nil {
+			return false, err
+		}
+		columnsByRow[row] = append(columnsByRow[row], column)
+	}
+
+	neededRows := (len(seats) + columns - 1) / columns
+	if len(columnsByRow) > neededRows {
+		return true, nil
+	}
+	for _, rowColumns := range columnsByRow {
+		sort.Ints(rowColumns)
+		for i := 1; i < len(rowColumns); i++ {
+			if rowColumns[i] != rowColumns[i-1]+1 {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// parseSeat splits a seat into its row and column index (e.g., "12-C" -> 12, 2).
+func parseSeat(seat string) (int, int, error) {
+	rowStr, columnStr, ok := strings.Cut(seat, "-")
+	if !ok || len(columnStr) != 1 {
+		return 0, 0, fmt.Errorf("invalid seat: %s", seat)
+	}
+	row, err := strconv.Atoi(rowStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid seat row of %s: %w", seat, err)
+	}
+	column := strings.Index(seatColumnLetters, columnStr)
+	if column == -1 {
+		return 0, 0, fmt.Errorf("invalid seat column of %s", seat)
+	}
+	return row, column, nil
+}
+
+// quoteReservationPrice returns the price the application should show for the seats of a schedule.
+// ok is false if the price cannot be checked because the model of the train is unknown.
+func (s *Scenario) quoteReservationPrice(scheduleID, fromStation, toStation string, seats []string) (priceQuote, bool, error) {
+	model, ok := s.trainModels.modelOf(trainNameFromScheduleID(scheduleID))
+	if !ok {
+		return priceQuote{}, false, nil
+	}
+	quote, err := quotePrice(s.line, model, s.line.stationID(fromStation), s.line.stationID(toStation), seats)
+	if err != nil {
+		return priceQuote{}, false, err
+	}
+	return quote, true, nil
+}
+
+// verifyReservationPrice checks the price of a reservation returned by /api/reserve against the pricing model.
+// The price of a mismatching reservation is zeroed, so its sales never count to the score.
+func (s *Scenario) verifyReservationPrice(reservation *Reservation, user User) {
+	quote, ok, err := s.quoteReservationPrice(reservation.ScheduleID, reservation.FromStation, reservation.ToStation, reservation.Seats)
+	if err != nil {
+		s.log.Error("Failed to quote the price of the reservation", "reservation_id", reservation.ReservationID, "error", err.Error(), "user", user.Name)
+	}
+	if !ok || (reservation.TotalPrice == quote.TotalPrice && reservation.IsDiscounted == quote.IsDiscounted) {
+		return
+	}
+
+	v := priceMismatch(reservation.ReservationID, "/api/reserve", quote, reservation.TotalPrice, reservation.IsDiscounted)
+	v.Message += ". Its sales are not counted"
+	s.log.Error("Wrong reservation price", "error", v.Message, "user", user.Name)
+	s.violations.add(v)
+	reservation.TotalPrice = 0
+}
+
+func priceMismatch(reservationID, source string, want priceQuote, gotPrice int, gotDiscounted bool) Violation {
+	return Violation{
+		Kind: ViolationPriceMismatch,
+		Message: fmt.Sprintf("price of reservation %s in %s is %d (is_discounted: %t), but expected %d (is_discounted: %t)",
+			reservationID, source, gotPrice, gotDiscounted, want.TotalPrice, want.IsDiscounted),
+		Details: map[string]string{
+			"reservation_id":         reservationID,
+			"source":                 source,
+			"total_price":            strconv.Itoa(gotPrice),
+			"is_discounted":          strconv.FormatBool(gotDiscounted),
+			"expected_total_price":   strconv.Itoa(want.TotalPrice),
+			"expected_is_discounted": strconv.FormatBool(want.IsDiscounted),
+		},
+	}
+}
+
+// verifyPurchasedTicketPrice checks the price of a ticket listed in /api/purchased_tickets against the pricing model.
+// The list has no is_discounted, so only the price is compared.
+func (s *Scenario) verifyPurchasedTicketPrice(ticket PurchasedTicket) (Violation, bool) {
+	quote, ok, err := s.quoteReservationPrice(ticket.ScheduleID, ticket.FromStation, ticket.ToStation, ticket.Seats)
+	if err != nil {
+		s.log.Error("Failed to quote the price of the ticket", "reservation_id", ticket.ReservationID, "error", err.Error())
+	}
+	if !ok || ticket.TotalPrice == quote.TotalPrice {
+		return Violation{}, false
+	}
+	return priceMismatch(ticket.ReservationID, "/api/purchased_tickets", quote, ticket.TotalPrice, quote.IsDiscounted), true
+}
@@ -0,0 +1,60 @@
+package bench
+
+import "testing"
+
+func TestFareDistance(t *testing.T) {
+	line := testLine(t)
+	tests := []struct {
+		from, to string
+		want     int
+	}{
+		{"A", "B", 1},
+		{"A", "E", 4},
+		{"E", "D", 1},
+		// Trips toward Arena are charged via Edge
+		{"D", "A", 5},
+		{"D", "C", 3},
+		{"B", "A", 7},
+	}
+	for _, tt := range tests {
+		if got := line.fareDistance(tt.from, tt.to); got != tt.want {
+			t.Errorf("fareDistance(%s, %s) = %d, want %d", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestQuotePrice(t *testing.T) {
+	line := testLine(t)
+	economy5 := trainModel{Name: "Economy-5", SeatRows: 10, SeatColumns: 5}
+	luxury2 := trainModel{Name: "Luxury-2", SeatRows: 2, SeatColumns: 2}
+
+	tests := []struct {
+		name     string
+		model    trainModel
+		from, to string
+		seats    []string
+		want     priceQuote
+	}{
+		{"single seat", economy5, "A", "C", []string{"3-C"}, priceQuote{TotalPrice: 2000}},
+		{"single seat via Edge", economy5, "D", "A", []string{"1-A"}, priceQuote{TotalPrice: 5000}},
+		{"adjacent seats", economy5, "A", "B", []string{"1-B", "1-A", "1-C"}, priceQuote{TotalPrice: 3000}},
+		{"gap in a row", economy5, "A", "B", []string{"1-A", "1-C"}, priceQuote{TotalPrice: 1000, IsDiscounted: true}},
+		{"more rows than needed", economy5, "A", "C", []string{"1-E", "2-A"}, priceQuote{TotalPrice: 2000, IsDiscounted: true}},
+		{"rows needed for the group", luxury2, "A", "B", []string{"1-A", "1-B", "2-A"}, priceQuote{TotalPrice: 3000}},
+		{"two digit rows", economy5, "A", "B", []string{"10-A", "10-B", "9-E", "9-D"}, priceQuote{TotalPrice: 2000, IsDiscounted: true}},
+	}
+	for _, tt := range tests {
+		got, err := quotePrice(line, tt.model, tt.from, tt.to, tt.seats)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: quotePrice = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := quotePrice(line, economy5, "A", "B", []string{"1-A", "1-Z"}); err == nil {
+		t.Error("expected an error for an invalid seat")
+	}
+}
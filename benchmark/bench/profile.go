@@ -0,0 +1,282 @@
+package bench
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/showwin/ISHOCON3/benchmark/bench/data"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile defines how the load grows during a benchmark run.
+type Profile struct {
+	// Duration is the length of the load phase
+	Duration time.Duration `yaml:"duration"`
+	// RefundGracePeriod is the maximum time to wait for pending refunds after the load phase
+	RefundGracePeriod time.Duration `yaml:"refund_grace_period"`
+	// TicketPhases are driven by the number of tickets sold
+	TicketPhases PhaseProfile `yaml:"ticket_phases"`
+	// SalesPhases are driven by the total sales
+	SalesPhases PhaseProfile `yaml:"sales_phases"`
+	// WaitingRoom is the admission the application is expected to enforce
+	WaitingRoom WaitingRoomProfile `yaml:"waiting_room"`
+	// HTTP limits the connections of each user to the application
+	HTTP HTTPProfile `yaml:"http"`
+	// Arrivals limits the users of the open load mode
+	Arrivals ArrivalProfile `yaml:"arrivals"`
+	// Clock is the clock the application is expected to run
+	Clock ClockProfile `yaml:"clock"`
+	// Scoring selects and configures the scorer of the run
+	Scoring ScoringProfile `yaml:"scoring"`
+}
+
+// WaitingRoomProfile is the expected behavior of the waiting room of the application.
+type WaitingRoomProfile struct {
+	// MaxActiveUsers is the number of users the application lets out of the waiting room at the same time.
+	// Admissions are not checked if 0.
+	MaxActiveUsers int `yaml:"max_active_users"`
+	// StarvationTimeout is how long a user may wait while users who arrived later are admitted.
+	// Starvation is not checked if 0.
+	StarvationTimeout time.Duration `yaml:"starvation_timeout"`
+}
+
+// HTTPProfile is the connection pool of each user of the benchmark, like the one of a browser.
+type HTTPProfile struct {
+	// MaxConnsPerUser is the number of connections a user may open at the same time. Unlimited if 0.
+	MaxConnsPerUser int `yaml:"max_conns_per_user"`
+	// MaxIdleConnsPerUser is the number of idle connections a user keeps for later requests. 2 if 0.
+	MaxIdleConnsPerUser int `yaml:"max_idle_conns_per_user"`
+	// IdleConnTimeout is how long an idle connection is kept. Idle connections are kept until the user leaves if 0.
+	IdleConnTimeout time.Duration `yaml:"idle_conn_timeout"`
+}
+
+// ArrivalProfile is how the users of the open load mode wait to start.
+type ArrivalProfile struct {
+	// MaxConcurrentUsers is the number of users running at the same time. Later arrivals wait for a user to finish.
+	// Unlimited if 0.
+	MaxConcurrentUsers int `yaml:"max_concurrent_users"`
+	// Patience is how long an arrival waits to start before it is abandoned. Arrivals wait until the end if 0.
+	Patience time.Duration `yaml:"patience"`
+}
+
+// ClockProfile is the clock of the application and how it is checked.
+type ClockProfile struct {
+	// Speed is the seconds passing in the application per real second. DefaultClockSpeed is used if 0.
+	Speed float64 `yaml:"speed"`
+	// DriftTolerance is how far /api/current_time may be from the clock of the benchmark in application time
+	DriftTolerance time.Duration `yaml:"drift_tolerance"`
+	// DriftCheckInterval is the real time between periodic checks of /api/current_time. Not checked periodically if 0.
+	DriftCheckInterval time.Duration `yaml:"drift_check_interval"`
+}
+
+// ScoringProfile is how the run is scored.
+type ScoringProfile struct {
+	// Scorer is the name of the scorer (one of ScorerNames). The sales scorer is used if empty.
+	Scorer     string            `yaml:"scorer"`
+	LatencySLO LatencySLOProfile `yaml:"latency_slo"`
+	ErrorRate  ErrorRateProfile  `yaml:"error_rate"`
+}
+
+// LatencySLOProfile is the latency each route is expected to meet with the latency_slo scorer.
+type LatencySLOProfile struct {
+	// P99 is the maximum p99 latency of each route
+	P99 time.Duration `yaml:"p99"`
+	// Penalty is the points deducted per route over the SLO
+	Penalty int64 `yaml:"penalty"`
+}
+
+// ErrorRateProfile is the rate of failed requests allowed with the error_rate scorer.
+type ErrorRateProfile struct {
+	// MaxRate is the percentage of failed requests allowed without a deduction
+	MaxRate float64 `yaml:"max_rate"`
+	// PenaltyPerPercent is the points deducted per percent of failed requests above MaxRate
+	PenaltyPerPercent int64 `yaml:"penalty_per_percent"`
+}
+
+// PhaseProfile is a series of phases sharing the same train configs CSV.
+type PhaseProfile struct {
+	// InitialWorkers is the number of buyers active from the start
+	InitialWorkers int `yaml:"initial_workers"`
+	// InitialArrivalRate is the number of users arriving per second from the start in the open load mode
+	InitialArrivalRate float64             `yaml:"initial_arrival_rate"`
+	Phases             []RegistrationPhase `yaml:"phases"`
+}
+
+// RegistrationPhase is reached when the tickets sold or the sales reach Threshold.
+type RegistrationPhase struct {
+	Threshold int64 `yaml:"threshold"`
+	// TrainCount is the number of trains registered when the phase is reached
+	TrainCount int `yaml:"train_count"`
+	// Workers is the number of buyers added when the phase is reached
+	Workers int `yaml:"workers"`
+	// ArrivalRate is the number of users per second added when the phase is reached in the open load mode
+	ArrivalRate float64 `yaml:"arrival_rate"`
+}
+
+// workerCounts returns the number of workers added per phase index.
+// Index 0 is the initial phase, and index i is reached by Phases[i-1].
+func (p PhaseProfile) workerCounts() []int {
+	counts := make([]int, 0, len(p.Phases)+1)
+	counts = append(counts, p.InitialWorkers)
+	for _, phase := range p.Phases {
+		counts = append(counts, phase.Workers)
+	}
+	return counts
+}
+
+// arrivalRates returns the arrival rate added per phase index in users per second.
+// Index 0 is the initial phase, and index i is reached by Phases[i-1].
+func (p PhaseProfile) arrivalRates() []float64 {
+	rates := make([]float64, 0, len(p.Phases)+1)
+	rates = append(rates, p.InitialArrivalRate)
+	for _, phase := range p.Phases {
+		rates = append(rates, phase.ArrivalRate)
+	}
+	return rates
+}
+
+// trainCount returns the number of trains registered through all phases.
+func (p PhaseProfile) trainCount() int {
+	var total int
+	for _, phase := range p.Phases {
+		total += phase.TrainCount
+	}
+	return total
+}
+
+// DefaultProfile returns the profile embedded in the benchmark.
+func DefaultProfile() (*Profile, error) {
+	profile, err := parseProfile([]byte(data.DefaultProfileYAML))
+	if err != nil {
+		return nil, fmt.Errorf("invalid default profile: %w", err)
+	}
+	return profile, nil
+}
+
+// LoadProfile reads and validates a profile from a YAML or JSON file.
+func LoadProfile(path string) (*Profile, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile: %w", err)
+	}
+	profile, err := parseProfile(buf)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile %s: %w", path, err)
+	}
+	return profile, nil
+}
+
+// parseProfile decodes a profile. JSON is accepted as it is a subset of YAML.
+func parseProfile(buf []byte) (*Profile, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(buf))
+	decoder.KnownFields(true)
+
+	var profile Profile
+	if err := decoder.Decode(&profile); err != nil {
+		return nil, fmt.Errorf("failed to parse profile: %w", err)
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// Validate checks the profile can be run with the embedded train configs.
+func (p *Profile) Validate() error {
+	if p.Duration <= 0 {
+		return errors.New("duration must be positive")
+	}
+	if p.RefundGracePeriod < 0 {
+		return errors.New("refund_grace_period must not be negative")
+	}
+	if err := p.TicketPhases.validate("ticket_phases", "ticket_sold"); err != nil {
+		return err
+	}
+	if err := p.SalesPhases.validate("sales_phases", "sales"); err != nil {
+		return err
+	}
+	if p.WaitingRoom.MaxActiveUsers < 0 {
+		return errors.New("waiting_room.max_active_users must not be negative")
+	}
+	if p.WaitingRoom.StarvationTimeout < 0 {
+		return errors.New("waiting_room.starvation_timeout must not be negative")
+	}
+	if p.HTTP.MaxConnsPerUser < 0 {
+		return errors.New("http.max_conns_per_user must not be negative")
+	}
+	if p.HTTP.MaxIdleConnsPerUser < 0 {
+		return errors.New("http.max_idle_conns_per_user must not be negative")
+	}
+	if p.HTTP.IdleConnTimeout < 0 {
+		return errors.New("http.idle_conn_timeout must not be negative")
+	}
+	if p.Arrivals.MaxConcurrentUsers < 0 {
+		return errors.New("arrivals.max_concurrent_users must not be negative")
+	}
+	if p.Arrivals.Patience < 0 {
+		return errors.New("arrivals.patience must not be negative")
+	}
+	if p.Clock.Speed < 0 {
+		return errors.New("clock.speed must not be negative")
+	}
+	if p.Clock.DriftTolerance < 0 {
+		return errors.New("clock.drift_tolerance must not be negative")
+	}
+	if p.Clock.DriftCheckInterval < 0 {
+		return errors.New("clock.drift_check_interval must not be negative")
+	}
+	if _, err := NewScorer(p.Scoring.Scorer, p.Scoring); err != nil {
+		return fmt.Errorf("scoring.scorer: %w", err)
+	}
+	if p.Scoring.LatencySLO.P99 < 0 {
+		return errors.New("scoring.latency_slo.p99 must not be negative")
+	}
+	if p.Scoring.LatencySLO.Penalty < 0 {
+		return errors.New("scoring.latency_slo.penalty must not be negative")
+	}
+	if p.Scoring.ErrorRate.MaxRate < 0 {
+		return errors.New("scoring.error_rate.max_rate must not be negative")
+	}
+	if p.Scoring.ErrorRate.PenaltyPerPercent < 0 {
+		return errors.New("scoring.error_rate.penalty_per_percent must not be negative")
+	}
+	return nil
+}
+
+func (p PhaseProfile) validate(name string, csvType string) error {
+	if p.InitialWorkers < 0 {
+		return fmt.Errorf("%s.initial_workers must not be negative", name)
+	}
+	if p.InitialArrivalRate < 0 {
+		return fmt.Errorf("%s.initial_arrival_rate must not be negative", name)
+	}
+	var lastThreshold int64
+	for i, phase := range p.Phases {
+		if phase.Threshold <= lastThreshold {
+			return fmt.Errorf("%s.phases[%d].threshold must be positive and greater than the previous one", name, i)
+		}
+		if phase.TrainCount < 0 {
+			return fmt.Errorf("%s.phases[%d].train_count must not be negative", name, i)
+		}
+		if phase.Workers < 0 {
+			return fmt.Errorf("%s.phases[%d].workers must not be negative", name, i)
+		}
+		if phase.ArrivalRate < 0 {
+			return fmt.Errorf("%s.phases[%d].arrival_rate must not be negative", name, i)
+		}
+		lastThreshold = phase.Threshold
+	}
+
+	configs, err := readAllTrainConfigs(csvType)
+	if err != nil {
+		return fmt.Errorf("failed to read train configs: %w", err)
+	}
+	if p.trainCount() > len(configs) {
+		return fmt.Errorf("%s registers %d trains, but only %d train configs are available", name, p.trainCount(), len(configs))
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package bench
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultProfile(t *testing.T) {
+	profile, err := DefaultProfile()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if profile.Duration != 60*time.Second || profile.RefundGracePeriod != 10*time.Second {
+		t.Errorf("unexpected durations: %v, %v", profile.Duration, profile.RefundGracePeriod)
+	}
+	if got := profile.TicketPhases.workerCounts(); len(got) != 6 || got[0] != 5 {
+		t.Errorf("unexpected ticket phase worker counts: %v", got)
+	}
+	if got := profile.SalesPhases.trainCount(); got != 68 {
+		t.Errorf("expected 68 sales trains, got %d", got)
+	}
+	if profile.WaitingRoom.MaxActiveUsers != 5 || profile.WaitingRoom.StarvationTimeout != 30*time.Second {
+		t.Errorf("unexpected waiting room: %+v", profile.WaitingRoom)
+	}
+	if profile.Clock.Speed != DefaultClockSpeed || profile.Clock.DriftTolerance != 10*time.Minute {
+		t.Errorf("unexpected clock: %+v", profile.Clock)
+	}
+	if profile.HTTP.MaxConnsPerUser != 6 || profile.HTTP.IdleConnTimeout != 30*time.Second {
+		t.Errorf("unexpected http: %+v", profile.HTTP)
+	}
+	if profile.Scoring.Scorer != ScorerSales || profile.Scoring.LatencySLO.P99 != time.Second {
+		t.Errorf("unexpected scoring: %+v", profile.Scoring)
+	}
+}
+
+func TestParseProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		wantErr string
+	}{
+		{
+			name: "json",
+			profile: `{"duration": "30s", "refund_grace_period": "5s",
+				"ticket_phases": {"initial_workers": 1, "phases": [{"threshold": 5, "train_count": 1, "workers": 2}]},
+				"sales_phases": {"initial_workers": 1}}`,
+		},
+		{
+			name:    "zero duration",
+			profile: "duration: 0s\n",
+			wantErr: "duration must be positive",
+		},
+		{
+			name:    "unknown field",
+			profile: "duration: 60s\nworkers: 3\n",
+			wantErr: "field workers not found",
+		},
+		{
+			name: "decreasing threshold",
+			profile: `duration: 60s
+sales_phases:
+  phases:
+    - {threshold: 1000, train_count: 1, workers: 1}
+    - {threshold: 500, train_count: 1, workers: 1}
+`,
+			wantErr: "sales_phases.phases[1].threshold",
+		},
+		{
+			name: "too many trains",
+			profile: `duration: 60s
+ticket_phases:
+  phases:
+    - {threshold: 5, train_count: 100, workers: 1}
+`,
+			wantErr: "only 12 train configs are available",
+		},
+		{
+			name:    "negative max active users",
+			profile: "duration: 60s\nwaiting_room: {max_active_users: -1}\n",
+			wantErr: "waiting_room.max_active_users must not be negative",
+		},
+		{
+			name:    "negative max conns per user",
+			profile: "duration: 60s\nhttp: {max_conns_per_user: -1}\n",
+			wantErr: "http.max_conns_per_user must not be negative",
+		},
+		{
+			name:    "negative arrival rate",
+			profile: "duration: 60s\nsales_phases: {initial_arrival_rate: -1}\n",
+			wantErr: "sales_phases.initial_arrival_rate must not be negative",
+		},
+		{
+			name:    "unknown scorer",
+			profile: "duration: 60s\nscoring: {scorer: fastest}\n",
+			wantErr: "unknown scorer: fastest",
+		},
+		{
+			name:    "negative error rate penalty",
+			profile: "duration: 60s\nscoring: {error_rate: {penalty_per_percent: -1}}\n",
+			wantErr: "scoring.error_rate.penalty_per_percent must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseProfile([]byte(tt.profile))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
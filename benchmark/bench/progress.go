@@ -0,0 +1,163 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/showwin/ISHOCON3/benchmark/bench/logger"
+)
+
+// Interval to report the progress of a run to Config.OnProgress
+const progressInterval = 500 * time.Millisecond
+
+// Number of recent warnings kept for the progress report
+const recentWarningsSize = 5
+
+// Stages of a run reported in Progress
+const (
+	StagePreValidation  = "pre-validation"
+	StageLoad           = "load"
+	StageRefunds        = "waiting for refunds"
+	StagePostValidation = "post-validation"
+)
+
+// Progress is a snapshot of a running benchmark.
+type Progress struct {
+	Stage           string
+	ApplicationTime string
+	Elapsed         time.Duration
+	Duration        time.Duration
+	// ScoreEstimate is the score if the run finished now without violations
+	ScoreEstimate int64
+	TotalSales    int64
+	TotalTickets  int64
+
+	TicketPhase      int
+	TicketPhaseCount int
+	// NextTicketThreshold is the tickets sold to reach the next phase. 0 if all phases are reached.
+	NextTicketThreshold int64
+	SalesPhase          int
+	SalesPhaseCount     int
+	// NextSalesThreshold is the sales to reach the next phase. 0 if all phases are reached.
+	NextSalesThreshold int64
+
+	ActiveBuyers int64
+	// RequestRate and ErrorRate are per second since the last report.
+	// Errors are non-2xx responses, timeouts and connection errors.
+	RequestRate float64
+	ErrorRate   float64
+	// RecentWarnings are the latest error logs, oldest first
+	RecentWarnings []string
+}
+
+// warningRecorder keeps the latest error logs.
+type warningRecorder struct {
+	mu       sync.Mutex
+	warnings []string
+}
+
+func (r *warningRecorder) add(warning string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.warnings = append(r.warnings, warning)
+	if len(r.warnings) > recentWarningsSize {
+		r.warnings = r.warnings[len(r.warnings)-recentWarningsSize:]
+	}
+}
+
+func (r *warningRecorder) recent() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.warnings...)
+}
+
+// warningLogger records error logs to warningRecorder in addition to logging them.
+type warningLogger struct {
+	logger.Logger
+	warnings *warningRecorder
+}
+
+func (l *warningLogger) Error(msg string, keyvals ...interface{}) {
+	l.Logger.Error(msg, keyvals...)
+	l.warnings.add(formatWarning(msg, keyvals...))
+}
+
+func formatWarning(msg string, keyvals ...interface{}) string {
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvals[i] == "error" {
+			msg = fmt.Sprintf("%s: %v", msg, keyvals[i+1])
+		}
+	}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvals[i] == "user" {
+			msg = fmt.Sprintf("%s (%v)", msg, keyvals[i+1])
+		}
+	}
+	return msg
+}
+
+func (s *Scenario) setStage(stage string) {
+	s.stage.Store(stage)
+}
+
+// progress returns a snapshot of the run. lastRequests and lastErrors are the totals of the previous snapshot.
+func (s *Scenario) progress(startedAt time.Time, interval time.Duration, lastRequests, lastErrors int64) (Progress, int64, int64) {
+	sales := sumShardedCounter(s.totalSales)
+	ticketPhase := int(s.currentTicketPhaseIndex.Load())
+	salesPhase := int(s.currentSalesPhaseIndex.Load())
+
+	p := Progress{
+		Stage:            s.stage.Load().(string),
+		ApplicationTime:  s.clock.Now(),
+		Elapsed:          time.Since(startedAt),
+		Duration:         s.profile.Duration,
+		ScoreEstimate:    calculateScore(sales, sumShardedCounter(s.totalPurchased), sumShardedCounter(s.totalRefunds)),
+		TotalSales:       sales,
+		TotalTickets:     sumShardedCounter(s.totalTickets),
+		TicketPhase:      ticketPhase,
+		TicketPhaseCount: len(s.profile.TicketPhases.Phases),
+		SalesPhase:       salesPhase,
+		SalesPhaseCount:  len(s.profile.SalesPhases.Phases),
+		RecentWarnings:   s.warnings.recent(),
+	}
+	if ticketPhase < len(s.profile.TicketPhases.Phases) {
+		p.NextTicketThreshold = s.profile.TicketPhases.Phases[ticketPhase].Threshold
+	}
+	if salesPhase < len(s.profile.SalesPhases.Phases) {
+		p.NextSalesThreshold = s.profile.SalesPhases.Phases[salesPhase].Threshold
+	}
+	for i := range s.activeTicketWorkers {
+		p.ActiveBuyers += s.activeTicketWorkers[i].Load()
+	}
+	for i := range s.activeSalesWorkers {
+		p.ActiveBuyers += s.activeSalesWorkers[i].Load()
+	}
+	if s.arrivals != nil {
+		p.ActiveBuyers += s.arrivals.active.Load()
+	}
+
+	requests, errors := s.requests.totals()
+	p.RequestRate = float64(requests-lastRequests) / interval.Seconds()
+	p.ErrorRate = float64(errors-lastErrors) / interval.Seconds()
+	return p, requests, errors
+}
+
+// reportProgress calls onProgress periodically until ctx is done.
+func (s *Scenario) reportProgress(ctx context.Context, startedAt time.Time, onProgress func(Progress)) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	var lastRequests, lastErrors int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var p Progress
+			p, lastRequests, lastErrors = s.progress(startedAt, progressInterval, lastRequests, lastErrors)
+			onProgress(p)
+		}
+	}
+}
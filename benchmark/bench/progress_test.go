@@ -0,0 +1,24 @@
+package bench
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestWarningRecorderKeepsLatest(t *testing.T) {
+	var r warningRecorder
+	for i := 0; i < recentWarningsSize+2; i++ {
+		r.add(fmt.Sprintf("warning %d", i))
+	}
+	recent := r.recent()
+	if len(recent) != recentWarningsSize || recent[0] != "warning 2" || recent[len(recent)-1] != fmt.Sprintf("warning %d", recentWarningsSize+1) {
+		t.Errorf("unexpected recent warnings: %v", recent)
+	}
+}
+
+func TestFormatWarning(t *testing.T) {
+	got := formatWarning("Failed to refund", "user", "alice", "error", "timeout")
+	if want := "Failed to refund: timeout (alice)"; got != want {
+		t.Errorf("formatWarning() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/png"
+	"mime"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// Bounds of the width and height of a QR code image in pixels.
+// A QR code has at least 21 modules per side, and the reference app renders 3300px images.
+const (
+	minQRCodeImageSize = 21
+	maxQRCodeImageSize = 5000
+)
+
+// QR code images larger than this are scaled down before decoding, because decoding the full image is slow
+const qrCodeDecodeSize = 400
+
+// verifyQRCodeImage checks the response of a QR code image is a square PNG image of a sane size
+// whose QR code holds entryToken.
+func verifyQRCodeImage(resp HttpResponse, entryToken string) error {
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("got %d status code", resp.StatusCode)
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "image/png" {
+		return fmt.Errorf("Content-Type is %q, not image/png", resp.Header.Get("Content-Type"))
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(resp.Body))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+	if format != "png" {
+		return fmt.Errorf("image format is %s, not png", format)
+	}
+	if config.Width != config.Height || config.Width < minQRCodeImageSize || config.Width > maxQRCodeImageSize {
+		return fmt.Errorf("image size %dx%d is not a square between %d and %d pixels", config.Width, config.Height, minQRCodeImageSize, maxQRCodeImageSize)
+	}
+
+	decodedToken, err := decodeQRCode(resp.Body)
+	if err != nil {
+		return err
+	}
+	if decodedToken != entryToken {
+		return fmt.Errorf("QR code does not match the entry token: expected %s, got %s", entryToken, decodedToken)
+	}
+	return nil
+}
+
+// decodeQRCode decodes a PNG image and returns the text embedded in the QR code.
+func decodeQRCode(imageData []byte) (string, error) {
+	img, _, err := image.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	bmp, err := gozxing.NewBinaryBitmapFromImage(scaleDown(img, qrCodeDecodeSize))
+	if err != nil {
+		return "", fmt.Errorf("failed to create bitmap: %w", err)
+	}
+
+	// The app renders a plain QR code on a white background, so try the fast pure barcode mode first
+	reader := qrcode.NewQRCodeReader()
+	result, err := reader.Decode(bmp, map[gozxing.DecodeHintType]interface{}{
+		gozxing.DecodeHintType_PURE_BARCODE: true,
+	})
+	if err != nil {
+		result, err = reader.Decode(bmp, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode QR code: %w", err)
+		}
+	}
+
+	return result.GetText(), nil
+}
+
+// scaleDown samples img by an integer step so that both sides are at most size pixels.
+// A QR code keeps its modules since they are many pixels wide in a large image.
+func scaleDown(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	longest := max(bounds.Dx(), bounds.Dy())
+	if longest <= size {
+		return img
+	}
+	step := (longest + size - 1) / size
+
+	scaled := image.NewGray(image.Rect(0, 0, (bounds.Dx()+step-1)/step, (bounds.Dy()+step-1)/step))
+	for y := 0; y < scaled.Rect.Dy(); y++ {
+		for x := 0; x < scaled.Rect.Dx(); x++ {
+			// Sample the center of the block
+			sx := min(bounds.Min.X+x*step+step/2, bounds.Max.X-1)
+			sy := min(bounds.Min.Y+y*step+step/2, bounds.Max.Y-1)
+			scaled.Set(x, y, img.At(sx, sy))
+		}
+	}
+	return scaled
+}
@@ -0,0 +1,91 @@
+package bench
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+func encodeQRCodePNG(t *testing.T, text string) []byte {
+	t.Helper()
+	return encodeQRCodePNGWithSize(t, text, 330, 330)
+}
+
+func encodeQRCodePNGWithSize(t *testing.T, text string, width, height int) []byte {
+	t.Helper()
+
+	matrix, err := qrcode.NewQRCodeWriter().EncodeWithoutHint(text, gozxing.BarcodeFormat_QR_CODE, width, height)
+	if err != nil {
+		t.Fatalf("failed to encode QR code: %v", err)
+	}
+
+	img := image.NewGray(image.Rect(0, 0, matrix.GetWidth(), matrix.GetHeight()))
+	for y := 0; y < matrix.GetHeight(); y++ {
+		for x := 0; x < matrix.GetWidth(); x++ {
+			if matrix.Get(x, y) {
+				img.SetGray(x, y, color.Gray{Y: 0})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeQRCode(t *testing.T) {
+	token := "01JDQ6Z7C4KDB5Y9V7Q3N2M8XW"
+
+	decoded, err := decodeQRCode(encodeQRCodePNG(t, token))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if decoded != token {
+		t.Errorf("Expected %s, got %s", token, decoded)
+	}
+
+	if _, err := decodeQRCode([]byte("not a png")); err == nil {
+		t.Errorf("Expected an error for invalid image data")
+	}
+}
+
+func TestVerifyQRCodeImage(t *testing.T) {
+	token := "01JDQ6Z7C4KDB5Y9V7Q3N2M8XW"
+	pngHeader := http.Header{"Content-Type": []string{"image/png"}}
+
+	// As large as the images of the reference app
+	large := HttpResponse{StatusCode: 200, Header: pngHeader, Body: encodeQRCodePNGWithSize(t, token, 3300, 3300)}
+	if err := verifyQRCodeImage(large, token); err != nil {
+		t.Errorf("Expected no error for a large image, got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		resp    HttpResponse
+		token   string
+		wantErr string
+	}{
+		{"another token", HttpResponse{StatusCode: 200, Header: pngHeader, Body: encodeQRCodePNG(t, token)}, "01JDQ6Z7C4KDB5Y9V7Q3N2M8XX", "does not match"},
+		{"not found", HttpResponse{StatusCode: 404, Header: pngHeader}, token, "404"},
+		{"wrong content type", HttpResponse{StatusCode: 200, Header: http.Header{"Content-Type": []string{"text/html"}}, Body: encodeQRCodePNG(t, token)}, token, "Content-Type"},
+		{"not a square", HttpResponse{StatusCode: 200, Header: pngHeader, Body: encodeQRCodePNGWithSize(t, token, 330, 400)}, token, "square"},
+		{"broken image", HttpResponse{StatusCode: 200, Header: pngHeader, Body: []byte("not a png")}, token, "decode"},
+	}
+	for _, tt := range tests {
+		err := verifyQRCodeImage(tt.resp, tt.token)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: expected an error containing %q, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package bench
+
+import (
+	"math/rand"
+)
+
+// Random number streams derived from the seed of the run.
+// Each goroutine making decisions owns its stream, so the decisions do not depend on scheduling.
+const (
+	streamPreValidation int64 = iota
+	streamPostValidation
+	streamAdmin
+	streamAdversary
+	// Stream of the arrivals in the open load mode
+	streamArrivals
+	// Stream of the i-th user worker is streamUserWorkers + i
+	streamUserWorkers
+)
+
+// newRandStream returns a generator for the stream derived from seed.
+// *rand.Rand is not safe for concurrent use, so do not share it between goroutines.
+func newRandStream(seed int64, stream int64) *rand.Rand {
+	return rand.New(rand.NewSource(mixSeed(uint64(seed) + uint64(stream)*0x9e3779b97f4a7c15)))
+}
+
+// mixSeed is the SplitMix64 finalizer. It keeps the streams of adjacent IDs uncorrelated.
+func mixSeed(z uint64) int64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
+
+// newRand returns a generator for the stream of this run.
+func (s *Scenario) newRand(stream int64) *rand.Rand {
+	return newRandStream(s.seed, stream)
+}
@@ -0,0 +1,82 @@
+package bench
+
+import (
+	"time"
+)
+
+// Config is the configuration of a benchmark run.
+type Config struct {
+	// TargetURL is the base URL of the application (e.g., "http://127.0.0.1:8080")
+	TargetURL string
+	// LogLevel is one of "debug", "info", "warn" and "error"
+	LogLevel string
+	// Profile defines the load of the run. The embedded default profile is used if nil.
+	Profile *Profile
+	// Seed of the random decisions of the users. A random seed is used if 0.
+	Seed int64
+	// MetricsAddr is the address to serve Prometheus metrics on during the run (e.g., ":9090"). Disabled if empty.
+	MetricsAddr string
+	// LoadMode is how the users are started. The closed mode is used if empty.
+	LoadMode LoadMode
+	// Scorer is the name of the scorer (one of ScorerNames). The one of the profile is used if empty.
+	Scorer string
+	// UniqueUsers hands out each user at most once, so no two sessions log in as the same user
+	UniqueUsers bool
+	// OnProgress is called periodically with the progress of the run if set
+	OnProgress func(Progress)
+}
+
+// Result is the outcome of a benchmark run.
+type Result struct {
+	Score int64
+	// Scorer is the name of the scorer which scored the run
+	Scorer string
+	// ScoreBreakdown is the terms the score is made of
+	ScoreBreakdown []ScoreTerm
+	TotalSales     int64
+	TotalPurchased int64
+	TotalRefunds   int64
+	TotalTickets   int64
+
+	// Number of reached phases out of the number of defined phases
+	TicketPhase      int
+	TicketPhaseCount int
+	SalesPhase       int
+	SalesPhaseCount  int
+
+	// CriticalError is the reason the benchmark was interrupted or failed. Empty if none.
+	CriticalError string
+	// CriticalErrors are all the critical errors in the order they were reported. The first one interrupted the benchmark.
+	CriticalErrors []CriticalError
+	Violations     []Violation
+
+	// Endpoints is the latency and outcome of the requests per route
+	Endpoints []EndpointStats
+	// WaitingRoom is how the users were admitted from the waiting room
+	WaitingRoom WaitingRoomStats
+	// Connections is how many connections the users opened to the application
+	Connections ConnectionStats
+	LoadMode    LoadMode
+	// Arrivals is how the users arrived in the open load mode. Zero in the closed mode.
+	Arrivals ArrivalStats
+	// Clock is how /api/current_time agreed with the clock of the benchmark
+	Clock ClockStats
+
+	AppLanguage string
+	// Seed reproduces the same user decisions when passed to Config.Seed
+	Seed int64
+	// ApplicationTime is the application clock ("HH:MM") when the load finished
+	ApplicationTime string
+	StartedAt       time.Time
+	FinishedAt      time.Time
+}
+
+// NetRevenue returns the sales minus the refunds.
+func (r *Result) NetRevenue() int64 {
+	return r.TotalSales - r.TotalRefunds
+}
+
+// Duration returns how long the benchmark took.
+func (r *Result) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
+}
@@ -10,7 +10,6 @@ import (
 	"io"
 	"math/rand"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/showwin/ISHOCON3/benchmark/bench/data"
@@ -55,45 +54,22 @@ type TrainConfig struct {
 	FirstDepartureTime string
 }
 
-type RegistrationPhase struct {
-	Threshold  int64
-	TrainCount int
-}
-
-// 12 trains in total
-var ticketSoldPhases = []RegistrationPhase{
-	{Threshold: 5, TrainCount: 1},
-	{Threshold: 10, TrainCount: 2},
-	{Threshold: 50, TrainCount: 3},
-	{Threshold: 100, TrainCount: 3},
-	{Threshold: 200, TrainCount: 3},
-}
-
-// 68 trains in total
-var salesPhases = []RegistrationPhase{
-	{Threshold: 1000, TrainCount: 3},
-	{Threshold: 3000, TrainCount: 3},
-	{Threshold: 10000, TrainCount: 5},
-	{Threshold: 50000, TrainCount: 7},
-	{Threshold: 200000, TrainCount: 10},
-	{Threshold: 500000, TrainCount: 20},
-	{Threshold: 1000000, TrainCount: 20},
-}
-
 func (s *Scenario) RunAdminScenario(ctx context.Context) {
-	agent, err := agent.NewAgent(agent.WithBaseURL(s.targetURL), agent.WithTimeout(10*time.Second), agent.WithDefaultTransport())
+	agent, err := s.newAgent()
 	if err != nil {
 		s.log.Error("Failed to create agent", "error", err.Error())
 		return
 	}
 
+	rng := s.newRand(streamAdmin)
+
 	// For safer staging after initialization
 	time.Sleep(1 * time.Second)
 
 	s.log.Info("Admin scenario started")
 
-	// The first check is at 00:40, so start after 4 seconds
-	ticker := time.NewTicker(4 * time.Second)
+	// The first check is at 00:40, so check every 40 minutes in app time
+	ticker := time.NewTicker(s.clock.RealDuration(40 * time.Minute))
 	defer ticker.Stop()
 
 	for {
@@ -114,7 +90,7 @@ func (s *Scenario) RunAdminScenario(ctx context.Context) {
 				if ctx.Err() != nil {
 					return
 				}
-				s.criticalError <- fmt.Errorf("failed to login as admin: %w", err)
+				s.criticalErrors.report(ErrorCategoryAdmin, fmt.Errorf("failed to login as admin: %w", err))
 				return
 			}
 			s.log.Info("POST /api/admin/login", "user", "admin")
@@ -125,7 +101,7 @@ func (s *Scenario) RunAdminScenario(ctx context.Context) {
 				if ctx.Err() != nil {
 					return
 				}
-				s.criticalError <- fmt.Errorf("failed to get train models: %w", err)
+				s.criticalErrors.report(ErrorCategoryAdmin, fmt.Errorf("failed to get train models: %w", err))
 				return
 			}
 			s.log.Info("GET /api/train_models", "user", "admin")
@@ -145,7 +121,7 @@ func (s *Scenario) RunAdminScenario(ctx context.Context) {
 				if ctx.Err() != nil {
 					return
 				}
-				s.criticalError <- fmt.Errorf("failed to get admin stats within 2 second: %w", err)
+				s.criticalErrors.report(ErrorCategoryStatsValidation, fmt.Errorf("failed to get admin stats within 2 second: %w", err))
 				return
 			}
 			s.log.Info("GET /api/admin/stats", "user", "admin")
@@ -159,7 +135,7 @@ func (s *Scenario) RunAdminScenario(ctx context.Context) {
 				if ctx.Err() != nil {
 					return
 				}
-				s.criticalError <- fmt.Errorf("failed to get train sales within 2 second: %w", err)
+				s.criticalErrors.report(ErrorCategoryStatsValidation, fmt.Errorf("failed to get train sales within 2 second: %w", err))
 				return
 			}
 			s.log.Info("GET /api/admin/train_sales", "user", "admin")
@@ -173,14 +149,14 @@ func (s *Scenario) RunAdminScenario(ctx context.Context) {
 				err := fmt.Errorf("total_sales too old: API returned %d, but minimum expected is %d",
 					stats.TotalSales, minExpectedSales)
 				s.log.Error("Stats validation failed", "error", err.Error(), "user", "admin")
-				s.criticalError <- err
+				s.criticalErrors.report(ErrorCategoryStatsValidation, err)
 				return
 			}
 			if stats.TotalSales > int64(float64(maxExpectedSales)*1.1) {
 				err := fmt.Errorf("total_sales too large: API returned %d, but maximum expected is %d",
 					stats.TotalSales, maxExpectedSales)
 				s.log.Error("Stats validation failed", "error", err.Error(), "user", "admin")
-				s.criticalError <- err
+				s.criticalErrors.report(ErrorCategoryStatsValidation, err)
 				return
 			}
 
@@ -188,14 +164,14 @@ func (s *Scenario) RunAdminScenario(ctx context.Context) {
 				err := fmt.Errorf("total_refunds too old: API returned %d, but minimum expected is %d",
 					stats.TotalRefunds, minExpectedRefunds)
 				s.log.Error("Stats validation failed", "error", err.Error(), "user", "admin")
-				s.criticalError <- err
+				s.criticalErrors.report(ErrorCategoryStatsValidation, err)
 				return
 			}
 			if stats.TotalRefunds > int64(float64(maxExpectedRefunds)*1.1) {
 				err := fmt.Errorf("total_refunds too large: API returned %d, but maximum expected is %d",
 					stats.TotalRefunds, maxExpectedRefunds)
 				s.log.Error("Stats validation failed", "error", err.Error(), "user", "admin")
-				s.criticalError <- err
+				s.criticalErrors.report(ErrorCategoryStatsValidation, err)
 				return
 			}
 
@@ -211,14 +187,14 @@ func (s *Scenario) RunAdminScenario(ctx context.Context) {
 				err := fmt.Errorf("total_tickets_sold too old: API returned %d, but minimum expected is %d",
 					totalTicketsSold, minExpectedTickets)
 				s.log.Error("Tickets validation failed", "error", err.Error(), "user", "admin")
-				s.criticalError <- err
+				s.criticalErrors.report(ErrorCategoryStatsValidation, err)
 				return
 			}
 			if totalTicketsSold > int64(float64(maxExpectedTickets)*1.1) {
 				err := fmt.Errorf("total_tickets_sold too large: API returned %d, but maximum expected is %d",
 					totalTicketsSold, maxExpectedTickets)
 				s.log.Error("Tickets validation failed", "error", err.Error(), "user", "admin")
-				s.criticalError <- err
+				s.criticalErrors.report(ErrorCategoryStatsValidation, err)
 				return
 			}
 
@@ -226,7 +202,7 @@ func (s *Scenario) RunAdminScenario(ctx context.Context) {
 			s.log.Info("Thinking whether to add new trains", "user", "admin")
 
 			// Register more trains based on tickets and sales
-			err = s.registerNewTrains(ctx, agent, totalTicketsSold, stats.TotalSales)
+			err = s.registerNewTrains(ctx, agent, rng, totalTicketsSold, stats.TotalSales)
 
 			if err != nil {
 				// Ignore errors due to main context cancellation (timeout)
@@ -234,7 +210,7 @@ func (s *Scenario) RunAdminScenario(ctx context.Context) {
 					return
 				}
 				s.log.Error("Failed to register trains", "error", err.Error(), "user", "admin")
-				s.criticalError <- fmt.Errorf("train registration failed: %w", err)
+				s.criticalErrors.report(ErrorCategoryAdmin, fmt.Errorf("train registration failed: %w", err))
 				return
 			}
 		}
@@ -242,11 +218,12 @@ func (s *Scenario) RunAdminScenario(ctx context.Context) {
 }
 
 // registerNewTrains checks if thresholds are exceeded and registers trains accordingly
-func (s *Scenario) registerNewTrains(ctx context.Context, agent *agent.Agent, currentTickets, currentSales int64) error {
+func (s *Scenario) registerNewTrains(ctx context.Context, agent *agent.Agent, rng *rand.Rand, currentTickets, currentSales int64) error {
 	// Check ticket sold
 	currentTicketPhase := int(s.currentTicketPhaseIndex.Load())
-	for i := currentTicketPhase; i < len(ticketSoldPhases); i++ {
-		phase := ticketSoldPhases[i]
+	ticketPhases := s.profile.TicketPhases.Phases
+	for i := currentTicketPhase; i < len(ticketPhases); i++ {
+		phase := ticketPhases[i]
 		if currentTickets >= phase.Threshold {
 			s.log.Info("Registering new trains based on ticket sold",
 				"current_phase", i+1,
@@ -258,13 +235,13 @@ func (s *Scenario) registerNewTrains(ctx context.Context, agent *agent.Agent, cu
 			// Calculate how many trains were already registered
 			var alreadyRegistered int
 			for j := 0; j < i; j++ {
-				alreadyRegistered += ticketSoldPhases[j].TrainCount
+				alreadyRegistered += ticketPhases[j].TrainCount
 			}
 
 			csvStart := alreadyRegistered
 			csvCount := phase.TrainCount
 
-			err := s.registerTrainsFromCSV(ctx, agent, "ticket_sold", csvStart, csvCount)
+			err := s.registerTrainsFromCSV(ctx, agent, rng, "ticket_sold", csvStart, csvCount)
 			if err != nil {
 				return fmt.Errorf("failed to register trains for ticket phase %d: %w", i, err)
 			}
@@ -278,6 +255,7 @@ func (s *Scenario) registerNewTrains(ctx context.Context, agent *agent.Agent, cu
 
 	// Check sales
 	currentSalesPhase := int(s.currentSalesPhaseIndex.Load())
+	salesPhases := s.profile.SalesPhases.Phases
 	for i := currentSalesPhase; i < len(salesPhases); i++ {
 		phase := salesPhases[i]
 		if currentSales >= phase.Threshold {
@@ -297,7 +275,7 @@ func (s *Scenario) registerNewTrains(ctx context.Context, agent *agent.Agent, cu
 			csvStart := alreadyRegistered
 			csvCount := phase.TrainCount
 
-			err := s.registerTrainsFromCSV(ctx, agent, "sales", csvStart, csvCount)
+			err := s.registerTrainsFromCSV(ctx, agent, rng, "sales", csvStart, csvCount)
 			if err != nil {
 				return fmt.Errorf("failed to register trains for sales phase %d: %w", i, err)
 			}
@@ -319,7 +297,7 @@ func (s *Scenario) registerNewTrains(ctx context.Context, agent *agent.Agent, cu
 }
 
 // registerTrainsFromCSV reads configs from CSV starting at a given index and registers trains
-func (s *Scenario) registerTrainsFromCSV(ctx context.Context, agent *agent.Agent, csvType string, startIndex, count int) error {
+func (s *Scenario) registerTrainsFromCSV(ctx context.Context, agent *agent.Agent, rng *rand.Rand, csvType string, startIndex, count int) error {
 	allConfigs, err := readAllTrainConfigs(csvType)
 	if err != nil {
 		s.log.Error("Failed to read all train configs", "csv_type", csvType, "error", err.Error(), "user", "admin")
@@ -336,7 +314,7 @@ func (s *Scenario) registerTrainsFromCSV(ctx context.Context, agent *agent.Agent
 
 	// Register each train
 	for _, config := range configs {
-		trainName := generateTrainName(config.ModelName, config.NamePrefix)
+		trainName := generateTrainName(rng, config.ModelName, config.NamePrefix)
 
 		departureTimes, err := generateDepartureTimes(config.FirstDepartureTime)
 		if err != nil {
@@ -361,15 +339,9 @@ func (s *Scenario) registerTrainsFromCSV(ctx context.Context, agent *agent.Agent
 	return nil
 }
 
-func generateTrainName(modelName string, namePrefix string) string {
-	// Business-4 -> B4
-	parts := strings.Split(modelName, "-")
-	for i := range parts {
-		parts[i] = string(parts[i][0])
-	}
-	modelPrefix := strings.Join(parts, "")
-	randomDigit := rand.Intn(10)
-	return fmt.Sprintf("%s%s%d", modelPrefix, namePrefix, randomDigit)
+func generateTrainName(rng *rand.Rand, modelName string, namePrefix string) string {
+	randomDigit := rng.Intn(10)
+	return fmt.Sprintf("%s%s%d", trainModelPrefix(modelName), namePrefix, randomDigit)
 }
 
 // generateDepartureTimes generates departure times every 3 hours starting from firstTime
@@ -457,7 +429,10 @@ func (s *Scenario) adminLogin(ctx context.Context, agent *agent.Agent) error {
 		return fmt.Errorf("failed to marshal login request: %w", err)
 	}
 
+	// The admin is a user of the application too, so it counts as an active user
+	activity := s.sessions.begin(reqBody.Name)
 	resp, err := HttpPost(ctx, agent, "/api/admin/login", bytes.NewReader(reqBodyBuf))
+	activity.end(err == nil && resp.StatusCode == 200)
 	if err != nil {
 		return fmt.Errorf("failed to post /api/admin/login: %w", err)
 	}
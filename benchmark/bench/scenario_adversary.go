@@ -0,0 +1,278 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/isucon/isucandar/agent"
+)
+
+// Interval between the requests of the adversary
+const adversaryInterval = 2 * time.Second
+
+// adversaryAttack is a request the application must reject.
+// It returns a description of the attack if the application accepted it, or "" if it was rejected.
+type adversaryAttack struct {
+	name string
+	run  func(s *Scenario, ctx context.Context, rng *rand.Rand) (string, error)
+}
+
+var adversaryAttacks = []adversaryAttack{
+	{name: "purchase_other_users_reservation", run: (*Scenario).attackPurchaseOtherUsersReservation},
+	{name: "refund_other_users_reservation", run: (*Scenario).attackRefundOtherUsersReservation},
+	{name: "replay_entry_token", run: (*Scenario).attackReplayEntryToken},
+	{name: "admin_stats_as_user", run: (*Scenario).attackAdminStatsAsUser},
+	{name: "reserve_without_login", run: (*Scenario).attackReserveWithoutLogin},
+}
+
+// RunAdversaryScenario tries a random request the application must reject at a low rate until ctx is done.
+// An accepted request is a security violation and stops the benchmark.
+func (s *Scenario) RunAdversaryScenario(ctx context.Context) {
+	rng := s.newRand(streamAdversary)
+
+	s.log.Info("Adversary scenario started")
+
+	ticker := time.NewTicker(adversaryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			attack := adversaryAttacks[rng.Intn(len(adversaryAttacks))]
+			accepted, err := attack.run(s, ctx, rng)
+			if err != nil {
+				if ShouldLogHTTPError(ctx, err) {
+					s.log.Warn("Adversary request failed", "attack", attack.name, "error", err.Error(), "user", "adversary")
+				}
+				continue
+			}
+			if accepted == "" {
+				s.log.Debug("Adversary request was rejected", "attack", attack.name, "user", "adversary")
+				continue
+			}
+			s.recordSecurityViolation(attack.name, accepted)
+		}
+	}
+}
+
+// recordSecurityViolation records a request which the application should have rejected and stops the benchmark.
+func (s *Scenario) recordSecurityViolation(attack string, accepted string) {
+	v := Violation{
+		Kind:    ViolationSecurity,
+		Message: fmt.Sprintf("Security violation: %s", accepted),
+		Details: map[string]string{"attack": attack},
+	}
+	s.log.Error("Security violation detected!", "error", v.Message, "user", "adversary")
+	s.violations.add(v)
+	s.criticalErrors.report(ErrorCategorySecurity, errors.New(v.Message))
+}
+
+// pickVictimTicket returns a random ticket of a user other than attacker which satisfies match.
+func (s *Scenario) pickVictimTicket(rng *rand.Rand, attacker User, match func(*ticketRecord) bool) (*ticketRecord, bool) {
+	var picked *ticketRecord
+	candidates := 0
+	s.ticketLedger.Range(func(_, value interface{}) bool {
+		record := value.(*ticketRecord)
+		if record.user.Name == attacker.Name || !match(record) {
+			return true
+		}
+		// Reservoir sampling keeps every candidate equally likely
+		candidates++
+		if rng.Intn(candidates) == 0 {
+			picked = record
+		}
+		return true
+	})
+	return picked, picked != nil
+}
+
+// adversarySession is the session of the attacker. It is only used by the adversary scenario.
+// The attacker is a single user, so it takes at most one of the active users the waiting room lets in,
+// whatever the number of attacks.
+// It is not tracked by the session tracker, since the attacks are not all proven to update the last activity.
+type adversarySession struct {
+	user       User
+	agent      *agent.Agent // nil until the first login
+	loggedInAt time.Time    // zero if not logged in
+}
+
+func newAdversarySession(user User) *adversarySession {
+	return &adversarySession{user: user}
+}
+
+// newAdversaryAgent returns the agent of the attacker, or a fresh agent if login is false.
+// The attacker logs in again only when the session may have expired, as not every attack updates the last activity.
+func (s *Scenario) newAdversaryAgent(ctx context.Context, login bool) (*agent.Agent, User, error) {
+	if !login {
+		agent, err := s.newAgent()
+		if err != nil {
+			return nil, User{}, fmt.Errorf("failed to create agent: %w", err)
+		}
+		return agent, User{}, nil
+	}
+
+	a := s.adversary
+	if a.agent == nil {
+		agent, err := s.newAgent()
+		if err != nil {
+			return nil, User{}, fmt.Errorf("failed to create agent: %w", err)
+		}
+		a.agent = agent
+		s.sessions.addUntrackedUser()
+	}
+	if !a.loggedInAt.IsZero() && time.Since(a.loggedInAt) < sessionIdleTimeout-sessionIdleTolerance {
+		return a.agent, a.user, nil
+	}
+
+	reqBody, err := json.Marshal(&LoginReq{Name: a.user.Name, Password: a.user.Password})
+	if err != nil {
+		return nil, User{}, err
+	}
+	start := time.Now()
+	resp, err := HttpPost(ctx, a.agent, "/api/login", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, User{}, err
+	}
+	s.log.Info("POST /api/login", "statusCode", resp.StatusCode, "user", "adversary")
+	if resp.StatusCode == 200 {
+		a.loggedInAt = start
+	}
+	// Without the session, the attack is rejected this time and the attacker logs in again on the next one
+	return a.agent, a.user, nil
+}
+
+func (s *Scenario) attackPurchaseOtherUsersReservation(ctx context.Context, rng *rand.Rand) (string, error) {
+	agent, attacker, err := s.newAdversaryAgent(ctx, true)
+	if err != nil {
+		return "", err
+	}
+	victim, ok := s.pickVictimTicket(rng, attacker, func(r *ticketRecord) bool {
+		return r.State() != ticketPurchaseFailed
+	})
+	if !ok {
+		return "", nil
+	}
+
+	reqBody, err := json.Marshal(PurchaseReq{ReservationID: victim.reservation.ReservationID})
+	if err != nil {
+		return "", err
+	}
+	resp, err := HttpPost(ctx, agent, "/api/purchase", bytes.NewReader(reqBody))
+	if err != nil {
+		return "", err
+	}
+	var purchaseResp PurchaseResp
+	if resp.StatusCode != 200 || json.Unmarshal(resp.Body, &purchaseResp) != nil || purchaseResp.Status != "success" {
+		return "", nil
+	}
+	return fmt.Sprintf("%s purchased reservation %s of %s", attacker.Name, victim.reservation.ReservationID, victim.user.Name), nil
+}
+
+func (s *Scenario) attackRefundOtherUsersReservation(ctx context.Context, rng *rand.Rand) (string, error) {
+	agent, attacker, err := s.newAdversaryAgent(ctx, true)
+	if err != nil {
+		return "", err
+	}
+	victim, ok := s.pickVictimTicket(rng, attacker, func(r *ticketRecord) bool {
+		_, entered := r.enteredToken()
+		return r.State() == ticketPurchased && !entered
+	})
+	if !ok {
+		return "", nil
+	}
+
+	reqBody, err := json.Marshal(RefundReq{ReservationID: victim.reservation.ReservationID})
+	if err != nil {
+		return "", err
+	}
+	resp, err := HttpPost(ctx, agent, "/api/refund", bytes.NewReader(reqBody))
+	if err != nil {
+		return "", err
+	}
+	var refundResp RefundResp
+	if resp.StatusCode != 200 || json.Unmarshal(resp.Body, &refundResp) != nil || refundResp.Status != "success" {
+		return "", nil
+	}
+	return fmt.Sprintf("%s refunded reservation %s of %s", attacker.Name, victim.reservation.ReservationID, victim.user.Name), nil
+}
+
+// attackReplayEntryToken passes the gate with the token of an entered ticket from another client.
+// An accepted replay is recorded as a duplicate entry, so it is penalized like the replays of the entry scenario
+// instead of failing the run.
+func (s *Scenario) attackReplayEntryToken(ctx context.Context, rng *rand.Rand) (string, error) {
+	victim, ok := s.pickVictimTicket(rng, User{}, func(r *ticketRecord) bool {
+		_, entered := r.enteredToken()
+		return entered
+	})
+	if !ok {
+		return "", nil
+	}
+	token, _ := victim.enteredToken()
+	replay, err := s.newEntryReplay(entryReplayAdversary, victim.reservation.ReservationID, token, victim.reservation.DepartureAt)
+	if err != nil {
+		return "", err
+	}
+
+	agent, _, err := s.newAdversaryAgent(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	s.replayEntry(ctx, agent, replay, User{Name: "adversary"})
+	return "", nil
+}
+
+func (s *Scenario) attackAdminStatsAsUser(ctx context.Context, rng *rand.Rand) (string, error) {
+	agent, attacker, err := s.newAdversaryAgent(ctx, true)
+	if err != nil {
+		return "", err
+	}
+	resp, err := HttpGet(ctx, agent, "/api/admin/stats")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != 200 {
+		return "", nil
+	}
+	return fmt.Sprintf("%s, who is not an admin, got /api/admin/stats", attacker.Name), nil
+}
+
+func (s *Scenario) attackReserveWithoutLogin(ctx context.Context, rng *rand.Rand) (string, error) {
+	// Reserve the same section of a schedule as a reservation of a user
+	ticket, ok := s.pickVictimTicket(rng, User{}, func(*ticketRecord) bool { return true })
+	if !ok {
+		return "", nil
+	}
+
+	agent, _, err := s.newAdversaryAgent(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	reqBody, err := json.Marshal(ReservationReq{
+		ScheduleID:    ticket.reservation.ScheduleID,
+		FromStationID: s.line.stationID(ticket.reservation.FromStation),
+		ToStationID:   s.line.stationID(ticket.reservation.ToStation),
+		NumPeople:     1,
+	})
+	if err != nil {
+		return "", err
+	}
+	resp, err := HttpPost(ctx, agent, "/api/reserve", bytes.NewReader(reqBody))
+	if err != nil {
+		return "", err
+	}
+	var reservationResp ReservationResp
+	if resp.StatusCode != 200 || json.Unmarshal(resp.Body, &reservationResp) != nil {
+		return "", nil
+	}
+	if reservationResp.Status != "success" && reservationResp.Status != "recommend" {
+		return "", nil
+	}
+	return "reserved a seat without logging in", nil
+}
@@ -0,0 +1,153 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/showwin/ISHOCON3/benchmark/bench/logger"
+)
+
+// newAdversaryTestScenario returns a scenario against a server answering every request with status.
+// The ledger has a purchased ticket and an entered ticket of other users.
+func newAdversaryTestScenario(t *testing.T, status int, body map[string]string) *Scenario {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(server.Close)
+
+	s := &Scenario{
+		targetURL:      server.URL,
+		clock:          NewVirtualClock(time.Now(), DefaultClockSpeed),
+		log:            logger.GetLogger("error"),
+		line:           testLine(t),
+		ticketLedger:   &sync.Map{},
+		adversary:      newAdversarySession(User{Name: "mallory"}),
+		violations:     &violationRecorder{},
+		sessions:       newSessionTracker(5),
+		criticalErrors: newErrorCollector(logger.GetLogger("error"), func() {}),
+		connections:    newConnectionPool(HTTPProfile{}, newRequestRecorder()),
+	}
+	s.recordReservation(User{Name: "alice"}, Reservation{ReservationID: "r1", ScheduleID: "E5001-1", FromStation: "Arena", ToStation: "Bridge", DepartureAt: "10:00"})
+	s.updateTicketState("r1", ticketPurchased)
+	s.recordReservation(User{Name: "bob"}, Reservation{ReservationID: "r2", ScheduleID: "E5001-1", FromStation: "Arena", ToStation: "Bridge", DepartureAt: "10:00"})
+	s.updateTicketState("r2", ticketPurchased)
+	s.recordEntry("r2", "token-r2")
+	return s
+}
+
+func TestAdversaryAttacksAccepted(t *testing.T) {
+	s := newAdversaryTestScenario(t, http.StatusOK, map[string]string{"status": "success"})
+	rng := rand.New(rand.NewSource(1))
+	for _, attack := range adversaryAttacks {
+		accepted, err := attack.run(s, context.Background(), rng)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", attack.name, err)
+		}
+		// An accepted replay is a duplicate entry, not a security violation
+		if attack.name == "replay_entry_token" {
+			if accepted != "" {
+				t.Errorf("%s: expected the replay not to be reported as a security violation", attack.name)
+			}
+			continue
+		}
+		if accepted == "" {
+			t.Errorf("%s: expected the attack to be reported as accepted", attack.name)
+		}
+	}
+	if violations := s.violations.all(); len(violations) != 1 || violations[0].Kind != ViolationDuplicateEntry {
+		t.Fatalf("expected a duplicate entry from the replay, got %+v", violations)
+	}
+
+	s.recordSecurityViolation("admin_stats_as_user", "mallory got /api/admin/stats")
+	if violations := s.violations.all(); len(violations) != 2 || !violations[1].Rule().Fail {
+		t.Errorf("expected a failing violation, got %+v", violations)
+	}
+	if errs := s.criticalErrors.all(); len(errs) != 1 || errs[0].Category != ErrorCategorySecurity {
+		t.Errorf("expected a security critical error, got %+v", errs)
+	}
+}
+
+func TestAdversaryAttacksRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   map[string]string
+	}{
+		{"unauthorized", http.StatusUnauthorized, map[string]string{"detail": "user_name cookie is required"}},
+		{"failed", http.StatusOK, map[string]string{"status": "fail", "error_code": "INVALID_RESERVATION"}},
+		{"already entered", http.StatusOK, map[string]string{"status": "already_entered"}},
+	}
+	for _, tt := range tests {
+		s := newAdversaryTestScenario(t, tt.status, tt.body)
+		rng := rand.New(rand.NewSource(1))
+		for _, attack := range adversaryAttacks {
+			if attack.name == "admin_stats_as_user" && tt.status == http.StatusOK {
+				continue
+			}
+			accepted, err := attack.run(s, context.Background(), rng)
+			if err != nil {
+				t.Errorf("%s, %s: unexpected error: %v", tt.name, attack.name, err)
+			}
+			if accepted != "" {
+				t.Errorf("%s, %s: expected the attack to be rejected, got %q", tt.name, attack.name, accepted)
+			}
+		}
+	}
+}
+
+func TestAdversaryLogsInOnce(t *testing.T) {
+	var mu sync.Mutex
+	logins := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/login" {
+			var req LoginReq
+			json.NewDecoder(r.Body).Decode(&req)
+			mu.Lock()
+			logins[req.Name]++
+			mu.Unlock()
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}))
+	t.Cleanup(server.Close)
+
+	s := &Scenario{
+		targetURL:   server.URL,
+		log:         logger.GetLogger("error"),
+		adversary:   newAdversarySession(User{Name: "mallory"}),
+		sessions:    newSessionTracker(5),
+		connections: newConnectionPool(HTTPProfile{}, newRequestRecorder()),
+	}
+	for i := 0; i < 3; i++ {
+		if _, _, err := s.newAdversaryAgent(context.Background(), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if logins["mallory"] != 1 {
+		t.Errorf("expected the attacker to log in once, got %v", logins)
+	}
+
+	// The attacker logs in again once the session may have expired
+	s.adversary.loggedInAt = time.Now().Add(-sessionIdleTimeout)
+	if _, _, err := s.newAdversaryAgent(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	if len(logins) != 1 || logins["mallory"] != 2 {
+		t.Errorf("expected the attacker to log in again, got %v", logins)
+	}
+
+	// The attacker is never surely active, but may fill the waiting room
+	if got := s.sessions.surelyActiveUsers(time.Now()); got != 0 {
+		t.Errorf("expected the attacker not to count as active, got %d", got)
+	}
+	if s.sessions.untracked != 1 {
+		t.Errorf("expected 1 untracked user, got %d", s.sessions.untracked)
+	}
+}
@@ -3,18 +3,12 @@ package bench
 import (
 	"bytes"
 	"context"
-	"encoding/csv"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
 	"math"
 	"math/rand"
-	"strconv"
 	"time"
 
-	"github.com/showwin/ISHOCON3/benchmark/bench/data"
-
 	"github.com/isucon/isucandar/agent"
 	"github.com/isucon/isucandar/worker"
 )
@@ -26,32 +20,12 @@ type User struct {
 	CreditAmount       int
 }
 
-type TrainAvailability struct {
-	ArenaToBridge string `json:"Arena->Bridge"` // "lots", "few", "none"
-	BridgeToCave  string `json:"Bridge->Cave"`
-	CaveToDock    string `json:"Cave->Dock"`
-	DockToEdge    string `json:"Dock->Edge"`
-	EdgeToDock    string `json:"Edge->Dock"`
-	DockToCave    string `json:"Dock->Cave"`
-	CaveToBridge  string `json:"Cave->Bridge"`
-	BridgeToArena string `json:"Bridge->Arena"`
-}
-
-type TrainDepartureAt struct {
-	ArenaToBridge string `json:"Arena->Bridge"` // "HH:MM" format
-	BridgeToCave  string `json:"Bridge->Cave"`
-	CaveToDock    string `json:"Cave->Dock"`
-	DockToEdge    string `json:"Dock->Edge"`
-	EdgeToDock    string `json:"Edge->Dock"`
-	DockToCave    string `json:"Dock->Cave"`
-	CaveToBridge  string `json:"Cave->Bridge"`
-	BridgeToArena string `json:"Bridge->Arena"`
-}
-
 type TrainSchedule struct {
-	ID           string            `json:"id"`
-	Availability TrainAvailability `json:"availability"`
-	DepartureAt  TrainDepartureAt  `json:"departure_at"`
+	ID string `json:"id"`
+	// Availability and DepartureAt are keyed by the section between adjacent stations (e.g., "Arena->Bridge").
+	// Availability is one of "lots", "few" and "none", and DepartureAt is "HH:MM".
+	Availability map[string]string `json:"availability"`
+	DepartureAt  map[string]string `json:"departure_at"`
 }
 
 type TrainScheduleResp struct {
@@ -65,10 +39,6 @@ type Itinerary struct {
 	DepartureTimes []time.Time
 }
 
-var (
-	stations = []string{"A", "B", "C", "D", "E"}
-)
-
 // type BoughtTicket struct {
 //   entryToken string
 //   departureAt string
@@ -125,16 +95,39 @@ type PurchaseResp struct {
 	QRCodeURL  string `json:"qr_code_url"`
 }
 
-func (s *Scenario) RunUserScenario(ctx context.Context) {
-	agent, err := agent.NewAgent(agent.WithBaseURL(s.targetURL), agent.WithTimeout(10*time.Second), agent.WithDefaultTransport())
+type PurchasedTicket struct {
+	ReservationID string   `json:"reservation_id"`
+	ScheduleID    string   `json:"schedule_id"`
+	FromStation   string   `json:"from_station"`
+	ToStation     string   `json:"to_station"`
+	DepartureAt   string   `json:"departure_at"`
+	Seats         []string `json:"seats"`
+	TotalPrice    int      `json:"total_price"`
+	EntryToken    string   `json:"entry_token"`
+	QRCodeURL     string   `json:"qr_code_url"`
+	IsEntered     bool     `json:"is_entered"`
+}
+
+type PurchasedTicketsResp struct {
+	Tickets []PurchasedTicket `json:"tickets"`
+}
+
+// RunUserScenario runs a session of a random user. rng must be owned by the calling worker.
+func (s *Scenario) RunUserScenario(ctx context.Context, rng *rand.Rand) {
+	user, err := s.getRandomUser(rng, false)
 	if err != nil {
-		s.log.Error("Failed to create agent", err.Error())
+		s.log.Error("Failed to get random user", "error", err.Error())
+		return
 	}
-
-	user, err := s.getRandomUser(false)
+	sess, err := s.newUserSession(user)
 	if err != nil {
-		s.log.Error("Failed to get random user", err.Error())
+		s.log.Error("Failed to create agent", "error", err.Error(), "user", user.Name)
+		return
 	}
+	defer sess.release()
+	agent := sess.agent
+	// The ticket scenario may outlive this call, so give it its own generator
+	sessionRng := rand.New(rand.NewSource(rng.Int63()))
 	s.log.Info("START", "user", user.Name)
 
 	s.postLogin(ctx, agent, user)
@@ -164,19 +157,32 @@ func (s *Scenario) RunUserScenario(ctx context.Context) {
 		scheduleWorker.Process(childCtx)
 	}()
 
+	// Some users log out as soon as they finish buying tickets instead of leaving the session idle
+	logout := rng.Float64() < logoutRate
+	sessionCtx, stopSession := context.WithCancel(ctx)
+	defer stopSession()
+
 	// Start worker to buy tickets
 	ticketScenarioWorker, err := worker.NewWorker(func(childCtx context.Context, _ int) {
-		s.runBuyTicketScenario(childCtx, ctx, agent, user)
+		s.runBuyTicketScenario(childCtx, ctx, sess, sessionRng)
 	}, worker.WithLoopCount(1), worker.WithMaxParallelism(1))
 	if err != nil {
 		s.log.Error("Failed to create runBuyTicketScenario worker", err.Error(), "user", user.Name)
 	}
 	go func() {
 		ticketScenarioWorker.Process(childCtx)
+		if logout {
+			stopSession()
+		}
 	}()
 
 	// Finish if the session is expired
-	s.checkSession(ctx, agent, user)
+	err = s.checkSession(sessionCtx, agent, user)
+	if logout && err != nil && sessionCtx.Err() != nil && ctx.Err() == nil {
+		if err := s.logoutAndVerify(ctx, agent, user); err != nil && ShouldLogHTTPError(ctx, err) {
+			s.log.Error("Failed to log out", "error", err.Error(), "user", user.Name)
+		}
+	}
 
 	s.log.Info("Session ended", "user", user.Name)
 }
@@ -187,8 +193,12 @@ func (s *Scenario) makeReservation(ctx context.Context, agent *agent.Agent, user
 		s.log.Error("Failed to parse JSON", err.Error(), "user", user.Name)
 		return nil, err
 	}
+	s.availability.beginReserve(req)
+	activity := s.sessions.begin(user.Name)
 	resp, err := HttpPost(ctx, agent, "/api/reserve", bytes.NewReader(reqBodyBuf))
+	activity.end(err == nil && resp.StatusCode == 200)
 	if err != nil {
+		s.availability.endReserve(req, nil, true)
 		if ShouldLogHTTPError(ctx, err) {
 			s.log.Error("Failed to post /api/reserve", err.Error(), "user", user.Name)
 		}
@@ -198,9 +208,21 @@ func (s *Scenario) makeReservation(ctx context.Context, agent *agent.Agent, user
 
 	var reservationResp ReservationResp
 	if err := json.Unmarshal(resp.Body, &reservationResp); err != nil {
+		s.availability.endReserve(req, nil, true)
 		s.log.Error("Failed to unmarshal response", err.Error(), "body", string(resp.Body), "user", user.Name)
 		return nil, err
 	}
+	var reservation *Reservation
+	if reservationResp.Reserved != nil {
+		reservation = reservationResp.Reserved
+	}
+	if reservationResp.Recommend != nil {
+		reservation = reservationResp.Recommend
+	}
+	if reservation != nil {
+		s.recordReservation(user, *reservation)
+	}
+	s.availability.endReserve(req, reservation, false)
 
 	return &reservationResp, nil
 }
@@ -211,7 +233,10 @@ func (s *Scenario) purchaseReservation(ctx context.Context, agent *agent.Agent,
 		s.log.Error("Failed to parse JSON", err.Error(), "user", user.Name)
 		return nil, err
 	}
+	s.updateTicketState(req.ReservationID, ticketPurchasing)
+	activity := s.sessions.begin(user.Name)
 	resp, err := HttpPost(ctx, agent, "/api/purchase", bytes.NewReader(reqBodyBuf))
+	activity.end(err == nil && resp.StatusCode == 200)
 	if err != nil {
 		if ShouldLogHTTPError(ctx, err) {
 			s.log.Error("Failed to post /api/purchase", err.Error(), "user", user.Name)
@@ -224,23 +249,24 @@ func (s *Scenario) purchaseReservation(ctx context.Context, agent *agent.Agent,
 	if err := json.Unmarshal(resp.Body, &purchaseResp); err != nil {
 		return nil, err
 	}
+	if purchaseResp.Status == "success" {
+		s.updateTicketState(req.ReservationID, ticketPurchased)
+	} else {
+		s.updateTicketState(req.ReservationID, ticketPurchaseFailed)
+		// The application releases the seats only when the payment failed
+		if purchaseResp.Status == "failed" {
+			s.releaseFailedPurchase(req.ReservationID)
+		}
+	}
 
 	return &purchaseResp, nil
 }
 
-func getApplicationClock(initializedAt time.Time) string {
-	timePassedInSec := time.Now().Sub(initializedAt).Seconds()
-	hours := int(math.Min(math.Floor(timePassedInSec/6), 24))
-	if hours == 24 {
-		return "24:00"
-	}
-	minutes := int(math.Mod(timePassedInSec, 6) * 10)
-	return fmt.Sprintf("%02d:%02d", hours, minutes)
-}
-
-func (s *Scenario) runBuyTicketScenario(ctx context.Context, parentCtx context.Context, agent *agent.Agent, user User) error {
+func (s *Scenario) runBuyTicketScenario(ctx context.Context, parentCtx context.Context, sess *userSession, rng *rand.Rand) error {
+	agent, user := sess.agent, sess.user
 	s.sendInitRequests(ctx, agent, user)
 
+	releases := s.availability.releaseCount()
 	resp, err := HttpGet(ctx, agent, "/api/schedules")
 	if err != nil {
 		if ShouldLogHTTPError(ctx, err) {
@@ -252,26 +278,27 @@ func (s *Scenario) runBuyTicketScenario(ctx context.Context, parentCtx context.C
 	if err := json.Unmarshal(resp.Body, &schedules); err != nil {
 		return err
 	}
+	s.validateNoneLabels(schedules.Schedules, releases, user)
 
 	// Return critical error if there is more than 10 schedules returned
 	if len(schedules.Schedules) > 10 {
-		s.criticalError <- fmt.Errorf("too many schedules returned. Max: 10. Returned: %d", len(schedules.Schedules))
+		s.criticalErrors.report(ErrorCategoryScheduleCount, fmt.Errorf("too many schedules returned. Max: 10. Returned: %d", len(schedules.Schedules)))
 		return fmt.Errorf("too many schedules returned: %d", len(schedules.Schedules))
 	}
 
-	itinerary := generateRandomItinerary()
+	itinerary := generateRandomItinerary(rng, s.line)
 	s.log.Info("Generated itinerary", "stations", itinerary.Stations, "user", user.Name)
 
-	currentTime := getApplicationClock(s.initializedAt)
+	currentTime := s.clock.Now()
 
-	numPeople := decideNumPeople(user.CreditAmount, itinerary)
+	numPeople := decideNumPeople(rng, s.line, user.CreditAmount, itinerary)
 
 	for i := 0; i < len(itinerary.Stations)-1; i++ {
 		from := itinerary.Stations[i]
 		to := itinerary.Stations[i+1]
 
 		// Find the earliest schedule for this leg after currentTime
-		schedule, departureTimeStr, err := findEarliestSchedule(from, to, currentTime, schedules.Schedules)
+		schedule, departureTimeStr, err := findEarliestSchedule(s.line, from, to, currentTime, schedules.Schedules)
 		if err != nil {
 			s.log.Warn("No available schedule found", "from", from, "to", to, "error", err.Error(), "user", user.Name)
 			return err
@@ -300,7 +327,7 @@ func (s *Scenario) runBuyTicketScenario(ctx context.Context, parentCtx context.C
 		} else if reservationResp.Status == "recommend" && reservationResp.Recommend != nil {
 			reservation = *reservationResp.Recommend
 			// Decide whether to proceed with recommendation
-			decision := rand.Float64()
+			decision := rng.Float64()
 			if decision < 0.2 {
 				s.log.Warn("Recommendation rejected with 20% probability, cancelling reservation", "recommendation_id", reservation.ReservationID, "user", user.Name)
 				return nil
@@ -308,10 +335,15 @@ func (s *Scenario) runBuyTicketScenario(ctx context.Context, parentCtx context.C
 			s.log.Info("Proceeding with recommended reservation", "reservation_id", reservation.ReservationID, "user", user.Name)
 		} else {
 			s.log.Error("Reservation failed", "status", reservationResp.Status, "error_code", reservationResp.ErrorCode, "user", user.Name)
+			if reservationResp.ErrorCode == "NO_SEAT_AVAILABLE" {
+				s.validateLotsLabel(*schedule, from, to, numPeople, user)
+			}
 			s.log.Info("A user is angry. Stopped buying any more tickets", "user", user.Name)
 			return nil
 		}
 
+		s.verifyReservationPrice(&reservation, user)
+
 		// Purchase the reservation
 		purchaseReq := PurchaseReq{
 			ReservationID: reservation.ReservationID,
@@ -327,38 +359,25 @@ func (s *Scenario) runBuyTicketScenario(ctx context.Context, parentCtx context.C
 			return nil
 		}
 		s.log.Info("Purchase succeeded", "reservation_id", reservation.ReservationID, "user", user.Name)
-		// Use random shard to reduce contention
-		shard := rand.Intn(32)
-		s.totalTickets[shard].Add(int64(len(reservation.Seats)))
-		s.totalPurchased[shard].Add(int64(reservation.TotalPrice))
-
-		// Track purchased reservations for double booking detection
-		// Store as "ScheduleID|Seat|FromTo" (e.g., "E2123|A-3|AD")
-		fromStation := stationNameToID(reservation.FromStation)
-		toStation := stationNameToID(reservation.ToStation)
-		fromTo := fromStation + toStation
-
-		for i, seat := range reservation.Seats {
-			reservationKey := fmt.Sprintf("%s|%s|%s", reservation.ScheduleID, seat, fromTo)
-			// Use unique key for each reservation (ReservationID + seat index)
-			uniqueKey := fmt.Sprintf("%s_%d", reservation.ReservationID, i)
-			s.purchasedReservations.Store(uniqueKey, reservationKey)
-		}
+		s.recordPurchase(user, reservation)
 
 		// Start worker to entry (use parent context for cancellation)
+		entryRng := rand.New(rand.NewSource(rng.Int63()))
 		entryScenarioWorker, err := worker.NewWorker(func(entryCtx context.Context, _ int) {
-			s.runEntryScenario(entryCtx, user, reservation, purchaseResp.EntryToken, purchaseResp.QRCodeURL)
+			s.runEntryScenario(entryCtx, entryRng, sess, reservation, purchaseResp.EntryToken, purchaseResp.QRCodeURL)
 		}, worker.WithLoopCount(1), worker.WithMaxParallelism(1))
 		if err != nil {
 			s.log.Error("Failed to create entry worker", err.Error(), "user", user.Name)
 		}
+		sess.acquire()
 		go func() {
+			defer sess.release()
 			entryScenarioWorker.Process(parentCtx)
 		}()
 
 		// Determine the next departure time
 		// Since the traveling time on the train never exceeds 2 hours, we can use the departure time 2-6 hours
-		hoursPassed := rand.Intn(5) + 2
+		hoursPassed := rng.Intn(5) + 2
 
 		departureTime, _ := time.Parse("15:04", reservation.DepartureAt)
 		nextDepartureTime := departureTime.Add(time.Duration(hoursPassed) * time.Hour)
@@ -370,6 +389,7 @@ func (s *Scenario) runBuyTicketScenario(ctx context.Context, parentCtx context.C
 		}
 
 		// Update schedules
+		releases := s.availability.releaseCount()
 		resp, err := HttpGet(ctx, agent, "/api/schedules")
 		if err != nil {
 			if ShouldLogHTTPError(ctx, err) {
@@ -380,13 +400,55 @@ func (s *Scenario) runBuyTicketScenario(ctx context.Context, parentCtx context.C
 		if err := json.Unmarshal(resp.Body, &schedules); err != nil {
 			return err
 		}
+		s.validateNoneLabels(schedules.Schedules, releases, user)
 	}
 
 	return nil
 }
 
+// recordPurchase adds a purchased reservation to the counters and checks its seats are not sold twice
+func (s *Scenario) recordPurchase(user User, reservation Reservation) {
+	// Use random shard to reduce contention
+	shard := rand.Intn(32)
+	s.totalTickets[shard].Add(int64(len(reservation.Seats)))
+	s.totalPurchased[shard].Add(int64(reservation.TotalPrice))
+
+	s.reportDoubleBookings(s.seatIndex.claim(reservation, user.Name, time.Now()))
+}
+
+func (s *Scenario) reportDoubleBookings(conflicts []seatConflict) {
+	for _, conflict := range conflicts {
+		s.log.Error("Double booking detected!", "error", conflict.Error(), "user", conflict.Second.User)
+		s.criticalErrors.report(ErrorCategoryDoubleBooking, conflict)
+	}
+}
+
+// beginRefund marks the seats of the reservation as refund pending before the refund is requested,
+// since the application may sell them to another user before it responds.
+func (s *Scenario) beginRefund(reservation Reservation) {
+	s.seatIndex.beginRefund(reservation)
+}
+
+// recordRefundFailure gives the seats back to a reservation the application refused to refund
+func (s *Scenario) recordRefundFailure(reservation Reservation) {
+	s.reportDoubleBookings(s.seatIndex.endRefund(reservation, false))
+}
+
+// recordRefund adds a refunded reservation to the counters and releases its seats
+func (s *Scenario) recordRefund(reservation Reservation) {
+	// Use random shard to reduce contention
+	shard := rand.Intn(32)
+	s.totalRefunds[shard].Add(int64(reservation.TotalPrice))
+
+	s.reportDoubleBookings(s.seatIndex.endRefund(reservation, true))
+	// Subtract refunded tickets from total tickets
+	s.totalTickets[shard].Add(-int64(len(reservation.Seats)))
+}
+
 func (s *Scenario) sendInitRequests(ctx context.Context, agent *agent.Agent, user User) {
+	activity := s.sessions.begin(user.Name)
 	resp, err := HttpGet(ctx, agent, "/api/purchased_tickets")
+	activity.end(err == nil && resp.StatusCode == 200)
 	if err != nil {
 		if ShouldLogHTTPError(ctx, err) {
 			s.log.Error("Failed to get /api/purchased_tickets", err.Error(), "user", user.Name)
@@ -401,8 +463,17 @@ func (s *Scenario) sendInitRequests(ctx context.Context, agent *agent.Agent, use
 		}
 	}
 	s.log.Info("GET /api/stations", "statusCode", resp.StatusCode, "user", user.Name)
+	if err == nil {
+		// The line must not change during the benchmark
+		var stationsResp StationsResp
+		if err := json.Unmarshal(resp.Body, &stationsResp); err != nil {
+			s.log.Error("Failed to unmarshal /api/stations response", "error", err.Error(), "user", user.Name)
+		} else if !s.line.matches(stationsResp.Stations) {
+			s.log.Error("Stations differ from the ones at the start of the benchmark", "user", user.Name)
+		}
+	}
 
-	resp, err = HttpGet(ctx, agent, "/api/current_time")
+	resp, err = s.checkCurrentTime(ctx, agent, user)
 	if err != nil {
 		if ShouldLogHTTPError(ctx, err) {
 			s.log.Error("Failed to get /api/current_time", err.Error(), "user", user.Name)
@@ -411,6 +482,31 @@ func (s *Scenario) sendInitRequests(ctx context.Context, agent *agent.Agent, use
 	s.log.Info("GET /api/current_time", "statusCode", resp.StatusCode, "user", user.Name)
 }
 
+func (s *Scenario) getPurchasedTickets(ctx context.Context, agent *agent.Agent, user User) (*PurchasedTicketsResp, error) {
+	activity := s.sessions.begin(user.Name)
+	resp, err := HttpGet(ctx, agent, "/api/purchased_tickets")
+	activity.end(err == nil && resp.StatusCode == 200)
+	if err != nil {
+		if ShouldLogHTTPError(ctx, err) {
+			s.log.Error("Failed to get /api/purchased_tickets", "error", err.Error(), "user", user.Name)
+		}
+		return nil, err
+	}
+	s.log.Info("GET /api/purchased_tickets", "statusCode", resp.StatusCode, "user", user.Name)
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("got %d status code from /api/purchased_tickets", resp.StatusCode)
+	}
+
+	var purchasedTickets PurchasedTicketsResp
+	if err := json.Unmarshal(resp.Body, &purchasedTickets); err != nil {
+		s.log.Error("Failed to unmarshal response", "error", err.Error(), "user", user.Name)
+		return nil, err
+	}
+
+	return &purchasedTickets, nil
+}
+
 func (s *Scenario) postLogin(ctx context.Context, agent *agent.Agent, user User) error {
 	s.log.Debug("POST /api/login", "user", user.Name)
 	reqBody := &LoginReq{
@@ -422,7 +518,9 @@ func (s *Scenario) postLogin(ctx context.Context, agent *agent.Agent, user User)
 		s.log.Error("Failed to parse JSON", err.Error(), "user", user.Name)
 		return err
 	}
+	activity := s.sessions.begin(user.Name)
 	resp, err := HttpPost(ctx, agent, "/api/login", bytes.NewReader(reqBodyBuf))
+	activity.end(err == nil && resp.StatusCode == 200)
 	if err != nil {
 		if ShouldLogHTTPError(ctx, err) {
 			s.log.Error("Failed to post /api/login", err.Error(), "user", user.Name)
@@ -434,22 +532,39 @@ func (s *Scenario) postLogin(ctx context.Context, agent *agent.Agent, user User)
 }
 
 func (s *Scenario) waitInWaitingRoom(ctx context.Context, agent *agent.Agent, user User) error {
+	waiter := s.waitingRoom.arrive(time.Now())
+	defer s.waitingRoom.leave(waiter)
 	for {
+		// The application updates the last activity only when the user is ready
+		activity := s.sessions.begin(user.Name)
 		resp, err := HttpGet(ctx, agent, "/api/waiting_status")
 		if err != nil {
+			activity.end(false)
 			return err
 		}
 
 		var waitingStatus WaitingStatusResp
 		if err := json.Unmarshal(resp.Body, &waitingStatus); err != nil {
+			activity.end(false)
 			return err
 		}
+		activity.end(waitingStatus.Status == "ready")
 
 		s.log.Debug("GET /api/waiting_status", "status", waitingStatus.Status, "next_check", waitingStatus.NextCheck, "user", user.Name)
 
 		if waitingStatus.Status == "ready" {
+			// The application counted the active users after the request was sent
+			if v, ok := s.waitingRoom.admit(waiter, time.Now(), s.sessions.surelyActiveUsers(activity.start)); ok {
+				s.recordSessionViolation(v, true, user)
+			}
 			break
 		} else if waitingStatus.Status == "waiting" {
+			if v, ok := s.sessions.checkWaiting(activity.start); ok {
+				s.recordSessionViolation(v, true, user)
+			}
+			if v, ok := s.waitingRoom.checkStarvation(waiter, time.Now()); ok {
+				s.recordSessionViolation(v, true, user)
+			}
 			time.Sleep(time.Duration(waitingStatus.NextCheck) * time.Millisecond)
 		} else {
 			s.log.Error("Unknown status", waitingStatus.Status, "Stopping requests.", "user", user.Name)
@@ -459,8 +574,11 @@ func (s *Scenario) waitInWaitingRoom(ctx context.Context, agent *agent.Agent, us
 	return nil
 }
 
+// checkSession polls /api/session until the session expires. It returns an error if ctx is done first.
 func (s *Scenario) checkSession(ctx context.Context, agent *agent.Agent, user User) error {
 	for {
+		before := s.sessions.snapshot(user.Name)
+		start := time.Now()
 		resp, err := HttpGet(ctx, agent, "/api/session")
 		if err != nil {
 			return err
@@ -470,101 +588,51 @@ func (s *Scenario) checkSession(ctx context.Context, agent *agent.Agent, user Us
 		if err := json.Unmarshal(resp.Body, &session); err != nil {
 			return err
 		}
+		if v, ok := checkSessionStatus(user.Name, session.Status, before, s.sessions.snapshot(user.Name), start, time.Now()); ok {
+			s.recordSessionViolation(v, false, user)
+		}
 
 		s.log.Debug("GET /api/session", "status", session.Status, "next_check", session.NextCheck, "user", user.Name)
 
-		// Check status
-		if session.Status == "session_expired" {
+		switch session.Status {
+		case "session_expired":
 			s.log.Info("Session expired. Logging out.", "user", user.Name)
-			break
-		} else if session.Status == "active" {
+			return nil
+		case "active":
 			// Wait next_check milliseconds before next request
-			time.Sleep(time.Duration(session.NextCheck) * time.Millisecond)
-		} else {
-			// Unknown status. TODO: decide what to do:
-			s.log.Info("Unknown status", session.Status, "Stopping requests.", "user", user.Name)
-			break
+			select {
+			case <-time.After(time.Duration(session.NextCheck) * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		default:
+			v := Violation{
+				Kind:    ViolationUnknownSessionStatus,
+				Message: fmt.Sprintf("/api/session returned an unknown status %q for %s", session.Status, user.Name),
+				Details: map[string]string{"user": user.Name, "status": session.Status},
+			}
+			s.log.Error("Unknown session status. Stopping requests.", "error", v.Message, "user", user.Name)
+			s.violations.add(v)
+			return nil
 		}
 	}
-	return nil
 }
 
-func (s *Scenario) getRandomUser(forValidation bool) (User, error) {
-	userTotalCount := 50001
-
-	// Use embedded CSV data instead of file system
-	reader := csv.NewReader(bytes.NewReader([]byte(data.UsersCSV)))
-	reader.TrimLeadingSpace = true
-
-	// Read the header line
-	headers, err := reader.Read()
-	if err != nil {
-		return User{}, fmt.Errorf("failed to read CSV headers: %w", err)
-	}
-
-	// Map headers to their indices for flexibility
-	headerMap := make(map[string]int)
-	for idx, header := range headers {
-		headerMap[header] = idx
-	}
-
-	// Ensure required headers are present
-	requiredHeaders := []string{"name", "password", "global_payment_token", "credit_amount"}
-	for _, header := range requiredHeaders {
-		if _, exists := headerMap[header]; !exists {
-			return User{}, fmt.Errorf("missing required header: %s", header)
-		}
-	}
-
-	var selectedUser User
-	var count int = 0
-	var index int
-	// Use different user pool for validation. Mod 23 is for validation.
+// getRandomUser picks a user from the validation pool or the load pool.
+func (s *Scenario) getRandomUser(rng *rand.Rand, forValidation bool) (User, error) {
 	if forValidation {
-		indexSeed := rand.Intn(userTotalCount / 23)
-		index = indexSeed * 23
-	} else {
-		for {
-			index = rand.Intn(userTotalCount)
-			if index%23 != 0 {
-				break
-			}
-		}
+		return s.validationUsers.pick(rng)
 	}
-
-	for {
-		record, err := reader.Read()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			return User{}, fmt.Errorf("error reading CSV record: %w", err)
-		}
-
-		count++
-
-		if count == index {
-			creditAmount, _ := strconv.Atoi(record[headerMap["credit_amount"]])
-			selectedUser = User{
-				Name:               record[headerMap["name"]],
-				Password:           record[headerMap["password"]],
-				GlobalPaymentToken: record[headerMap["global_payment_token"]],
-				CreditAmount:       creditAmount,
-			}
-			break
-		}
-	}
-
-	return selectedUser, nil
+	return s.loadUsers.pick(rng)
 }
 
-func findEarliestSchedule(from string, to string, after string, schedules []TrainSchedule) (*TrainSchedule, string, error) {
+func findEarliestSchedule(line *Line, from string, to string, after string, schedules []TrainSchedule) (*TrainSchedule, string, error) {
 	var earliestSchedule *TrainSchedule
 	departureTime := "24:00" // Initialize with the slowest time
 
 	for _, schedule := range schedules {
 		// Get the departure time string for this leg
-		scheduleDepartureStr := getScheduleDeparture(schedule.DepartureAt, from, to)
+		scheduleDepartureStr := line.departureAt(schedule, from, to)
 		if scheduleDepartureStr == "" {
 			return nil, "", fmt.Errorf("no departure time found for %s -> %s", from, to)
 		}
@@ -573,7 +641,7 @@ func findEarliestSchedule(from string, to string, after string, schedules []Trai
 			continue
 		}
 
-		if !getScheduleAvailability(schedule.Availability, from, to) {
+		if !line.isAvailable(schedule, from, to) {
 			continue
 		}
 
@@ -590,103 +658,10 @@ func findEarliestSchedule(from string, to string, after string, schedules []Trai
 	return earliestSchedule, departureTime, nil
 }
 
-func indexOf(slice []string, target string) int {
-	for i, v := range slice {
-		if v == target {
-			return i
-		}
-	}
-	return -1
-}
-
-func reverseSlice(s []string) []string {
-	n := len(s)
-	reversed := make([]string, n)
-	for i, v := range s {
-		reversed[n-1-i] = v
-	}
-	return reversed
-}
-
-func getScheduleDeparture(departureAt TrainDepartureAt, from string, to string) string {
-	var nextStation string
-	idx := indexOf(stations, from)
-	if from < to {
-		nextStation = stations[idx+1]
-	} else {
-		nextStation = stations[idx-1]
-	}
-	key := fmt.Sprintf("%s->%s", from, nextStation)
-
-	switch key {
-	case "A->B":
-		return departureAt.ArenaToBridge
-	case "B->C":
-		return departureAt.BridgeToCave
-	case "C->D":
-		return departureAt.CaveToDock
-	case "D->E":
-		return departureAt.DockToEdge
-	case "E->D":
-		return departureAt.EdgeToDock
-	case "D->C":
-		return departureAt.DockToCave
-	case "C->B":
-		return departureAt.CaveToBridge
-	case "B->A":
-		return departureAt.BridgeToArena
-	default:
-		return ""
-	}
-}
-
-func getScheduleAvailability(availability TrainAvailability, from string, to string) bool {
-	var stationsBetween []string
-	fromIdx := indexOf(stations, from)
-	toIdx := indexOf(stations, to)
-	if from < to {
-		stationsBetween = stations[fromIdx : toIdx+1]
-	} else {
-		stationsBetween = stations[toIdx : fromIdx+1]
-		stationsBetween = reverseSlice(stationsBetween)
-	}
-
-	var a string
-	for i := 0; i < len(stationsBetween)-1; i++ {
-		from := stationsBetween[i]
-		to := stationsBetween[i+1]
-		key := fmt.Sprintf("%s->%s", from, to)
-		switch key {
-		case "A->B":
-			a = availability.ArenaToBridge
-		case "B->C":
-			a = availability.BridgeToCave
-		case "C->D":
-			a = availability.CaveToDock
-		case "D->E":
-			a = availability.DockToEdge
-		case "E->D":
-			a = availability.EdgeToDock
-		case "D->C":
-			a = availability.DockToCave
-		case "C->B":
-			a = availability.CaveToBridge
-		case "B->A":
-			a = availability.BridgeToArena
-		default:
-			a = "none"
-		}
-		if a == "none" {
-			return false
-		}
-	}
-	return true
-}
-
-func generateRandomItinerary() *Itinerary {
+func generateRandomItinerary(rng *rand.Rand, line *Line) *Itinerary {
 	minStations := 2
 	maxStations := 5
-	numStations := rand.Intn(maxStations-minStations+1) + minStations
+	numStations := rng.Intn(maxStations-minStations+1) + minStations
 
 	itinerary := &Itinerary{
 		Stations:       make([]string, 0, numStations),
@@ -694,11 +669,12 @@ func generateRandomItinerary() *Itinerary {
 		DepartureTimes: make([]time.Time, 0, numStations),
 	}
 
-	currentStation := stations[rand.Intn(len(stations))]
+	stations := line.StationIDs()
+	currentStation := stations[rng.Intn(len(stations))]
 	itinerary.Stations = append(itinerary.Stations, currentStation)
 
 	for {
-		nextStations := stations[rand.Intn(len(stations))]
+		nextStations := stations[rng.Intn(len(stations))]
 
 		if currentStation == nextStations {
 			continue
@@ -714,7 +690,7 @@ func generateRandomItinerary() *Itinerary {
 	return itinerary
 }
 
-func decideNumPeople(creditAmount int, itinerary *Itinerary) int {
+func decideNumPeople(rng *rand.Rand, line *Line, creditAmount int, itinerary *Itinerary) int {
 	totalDistance := 0
 	baseTicketPrice := 1000
 	minPeople := 1
@@ -722,17 +698,17 @@ func decideNumPeople(creditAmount int, itinerary *Itinerary) int {
 	for i := 0; i < len(itinerary.Stations)-1; i++ {
 		from := itinerary.Stations[i]
 		to := itinerary.Stations[i+1]
-		totalDistance += int(math.Abs(float64(indexOf(stations, from) - indexOf(stations, to))))
+		totalDistance += line.distance(from, to)
 	}
 
 	costPerPerson := baseTicketPrice * totalDistance
 	// 90% of probability to choose lower than the maximum number of people
 	// 10% of probability to choose more than the maximum number of people
 	maxNumPeople := int(math.Ceil(float64(creditAmount) / float64(costPerPerson)))
-	if rand.Float64() < 0.9 {
+	if rng.Float64() < 0.9 {
 		if maxNumPeople < 15 {
 			// 与信の付与額上、15人以下が結構多いので、ランダムを取って期待値を半分に圧縮する
-			return rand.Intn(maxNumPeople) + 1
+			return rng.Intn(maxNumPeople) + 1
 		} else if maxNumPeople < minPeople {
 			return minPeople
 		} else if maxNumPeople > maxPeople {
@@ -741,21 +717,3 @@ func decideNumPeople(creditAmount int, itinerary *Itinerary) int {
 	}
 	return int(math.Min(float64(maxNumPeople+1), float64(maxPeople)))
 }
-
-// stationNameToID converts station name to ID (e.g., "Arena" -> "A", "Bridge" -> "B")
-func stationNameToID(stationName string) string {
-	switch stationName {
-	case "Arena":
-		return "A"
-	case "Bridge":
-		return "B"
-	case "Cave":
-		return "C"
-	case "Dock":
-		return "D"
-	case "Edge":
-		return "E"
-	default:
-		return ""
-	}
-}
@@ -12,6 +12,9 @@ import (
 	"github.com/isucon/isucandar/worker"
 )
 
+// Time in the application it takes a passenger to walk to the ticket gate
+const gateWalkTime = 10 * time.Minute
+
 type EntryReq struct {
 	EntryToken string `json:"entry_token"`
 }
@@ -29,8 +32,9 @@ type RefundResp struct {
 	ErrorCode string `json:"error_code,omitempty"`
 }
 
-func (s *Scenario) runEntryScenario(ctx context.Context, user User, reservation Reservation, entryToken string, qrCodeURL string) error {
-	currentTimeStr := getApplicationClock(s.initializedAt)
+func (s *Scenario) runEntryScenario(ctx context.Context, rng *rand.Rand, sess *userSession, reservation Reservation, entryToken string, qrCodeURL string) error {
+	user := sess.user
+	currentTimeStr := s.clock.Now()
 	departureAt := reservation.DepartureAt
 
 	// Wait until 1 hour before the departure time
@@ -49,11 +53,10 @@ func (s *Scenario) runEntryScenario(ctx context.Context, user User, reservation
 
 	var sleepDuration time.Duration
 	if waitTime > 0 {
-		sleepDuration = waitTime / 600 // 1 second in app time is 10 minutes in real time
+		sleepDuration = s.clock.RealDuration(waitTime)
 		s.log.Info("Waiting until 1 hour before departure", "wait_time", waitTime.String(), "wait_time_in_app", sleepDuration.String(), "departure_time", departureAt, "current_time", currentTimeStr, "user", user.Name)
 	} else {
-		// Moving to the gate takes 10 minutes in real time (1 second in app time)
-		sleepDuration = 1 * time.Second
+		sleepDuration = s.clock.RealDuration(gateWalkTime)
 	}
 
 	// Context-aware sleep that can be interrupted
@@ -65,62 +68,84 @@ func (s *Scenario) runEntryScenario(ctx context.Context, user User, reservation
 		return ctx.Err()
 	}
 
-	currentTimeStr = getApplicationClock(s.initializedAt)
+	currentTimeStr = s.clock.Now()
 	s.log.Info("Arrived at ticket gate", "departureAt", departureAt, "current_time", currentTimeStr, "entryToken", entryToken, "user", user.Name)
 
-	// Get QR code before entering the gate
-	qrResp, err := s.getQRCode(ctx, qrCodeURL, user)
+	// Get QR code before entering the gate. A passenger without a valid QR code cannot pass the gate, so the sale is lost.
+	qrResp, err := s.getQRCode(ctx, sess.agent, qrCodeURL, user)
 	if err != nil {
-		if ShouldLogHTTPError(ctx, err) {
-			s.log.Error("Failed to get QR code", "error", err.Error(), "qrCodeURL", qrCodeURL, "user", user.Name)
+		return err
+	}
+	s.log.Info("GET QR code", "statusCode", qrResp.StatusCode, "qrCodeURL", qrCodeURL, "user", user.Name)
+	if err := verifyQRCodeImage(qrResp, entryToken); err != nil {
+		v := Violation{
+			Kind:    ViolationInvalidQRCode,
+			Message: fmt.Sprintf("QR code %s of reservation %s is invalid: %s. Its sales are not counted", qrCodeURL, reservation.ReservationID, err.Error()),
+			Details: map[string]string{
+				"reservation_id": reservation.ReservationID,
+				"qr_code_url":    qrCodeURL,
+			},
 		}
-	} else {
-		s.log.Info("GET QR code", "statusCode", qrResp.StatusCode, "qrCodeURL", qrCodeURL, "user", user.Name)
+		s.log.Error("Invalid QR code", "error", v.Message, "user", user.Name)
+		s.violations.add(v)
+		return err
 	}
 
 	// Enter the ticket gate
-	resp, err := s.enterGate(ctx, EntryReq{EntryToken: entryToken}, user)
+	resp, err := s.enterGate(ctx, sess.agent, EntryReq{EntryToken: entryToken}, user)
 	if err != nil {
 		if ShouldLogHTTPError(ctx, err) {
 			s.log.Error("Failed to enter", "error", err.Error(), "token", entryToken)
 		}
 		return err
 	}
-	currentTimeStr = getApplicationClock(s.initializedAt)
+	currentTimeStr = s.clock.Now()
 
 	if resp.Status == "train_departed" {
 		s.log.Info("Train has already departed. The ticket was too close to departure time.", "token", entryToken, "departure_time", departureAt, "current_time", currentTimeStr, "user", user.Name)
 		s.log.Info("Logging in again to refund", "token", entryToken, "user", user.Name)
+		// rng is not safe for concurrent use, so decide before leaving this goroutine
+		replayAfterRefund := rng.Float64() < entryReplayRate
 		// Use a separate context with timeout for refund to allow it to complete even after main benchmark ends
-		s.refundWg.Add(1)
-		go func() {
-			defer s.refundWg.Done()
+		sess.acquire()
+		s.goRefund(func() {
+			defer sess.release()
 			refundCtx, refundCancel := context.WithTimeout(context.Background(), 30*time.Second)
 			defer refundCancel()
-			err := s.runRefundScenario(refundCtx, user, reservation)
+			err := s.runRefundScenario(refundCtx, sess, reservation)
 			if err != nil {
 				s.log.Error("Failed to refund", "error", err.Error(), "user", user.Name)
 				// Stop benchmark
-				s.criticalError <- fmt.Errorf("refund failed for user %s, reservation %s: %w", user.Name, reservation.ReservationID, err)
+				s.criticalErrors.report(ErrorCategoryRefund, fmt.Errorf("refund failed for user %s, reservation %s: %w", user.Name, reservation.ReservationID, err))
+				return
+			}
+			if replayAfterRefund {
+				s.replayEntryToken(refundCtx, sess.agent, entryReplayAfterRefund, reservation.ReservationID, entryToken, departureAt, user)
 			}
-		}()
+		})
 		return nil
 	}
 	s.log.Info("Entered the ticket gate", "departure_time", departureAt, "current_time", currentTimeStr, "token", entryToken, "from", reservation.FromStation, "to", reservation.ToStation, "user", user.Name)
+	s.recordEntry(reservation.ReservationID, entryToken)
 	// Add sales (use random shard to reduce contention)
 	shard := rand.Intn(32)
 	s.totalSales[shard].Add(int64(reservation.TotalPrice))
 	s.log.Info("Sales recorded", "amount", reservation.TotalPrice, "user", user.Name)
 
+	// Sometimes pass the gate again with the same token, or with the token of another reservation which already entered
+	switch r := rng.Float64(); {
+	case r < entryReplayRate:
+		s.replayEntryToken(ctx, sess.agent, entryReplaySameToken, reservation.ReservationID, entryToken, departureAt, user)
+	case r < 2*entryReplayRate:
+		if other, token, ok := s.pickOtherEnteredTicket(rng, reservation.ReservationID); ok {
+			s.replayEntryToken(ctx, sess.agent, entryReplayOtherReservation, other.reservation.ReservationID, token, other.reservation.DepartureAt, user)
+		}
+	}
+
 	return nil
 }
 
-func (s *Scenario) enterGate(ctx context.Context, req EntryReq, user User) (*EntryResp, error) {
-	agent, err := agent.NewAgent(agent.WithBaseURL(s.targetURL), agent.WithTimeout(10*time.Second), agent.WithDefaultTransport())
-	if err != nil {
-		s.log.Error("Failed to create agent", err.Error())
-	}
-
+func (s *Scenario) enterGate(ctx context.Context, agent *agent.Agent, req EntryReq, user User) (*EntryResp, error) {
 	reqBodyBuf, err := json.Marshal(req)
 	if err != nil {
 		s.log.Error("Failed to parse JSON", "error", err.Error(), "token", req.EntryToken, "user", user.Name)
@@ -148,13 +173,7 @@ func (s *Scenario) enterGate(ctx context.Context, req EntryReq, user User) (*Ent
 	return &entryResp, nil
 }
 
-func (s *Scenario) getQRCode(ctx context.Context, qrCodeURL string, user User) (HttpResponse, error) {
-	agent, err := agent.NewAgent(agent.WithBaseURL(s.targetURL), agent.WithTimeout(10*time.Second), agent.WithDefaultTransport())
-	if err != nil {
-		s.log.Error("Failed to create agent", err.Error())
-		return HttpResponse{}, err
-	}
-
+func (s *Scenario) getQRCode(ctx context.Context, agent *agent.Agent, qrCodeURL string, user User) (HttpResponse, error) {
 	resp, err := HttpGet(ctx, agent, qrCodeURL)
 	if err != nil {
 		if ShouldLogHTTPError(ctx, err) {
@@ -166,14 +185,12 @@ func (s *Scenario) getQRCode(ctx context.Context, qrCodeURL string, user User) (
 	return resp, nil
 }
 
-func (s *Scenario) runRefundScenario(ctx context.Context, user User, reservation Reservation) error {
-	agent, err := agent.NewAgent(agent.WithBaseURL(s.targetURL), agent.WithTimeout(10*time.Second), agent.WithDefaultTransport())
-	if err != nil {
-		s.log.Error("Failed to create agent", err.Error())
-	}
+// runRefundScenario logs in again with the session of the user, since it has likely expired while waiting for the train.
+func (s *Scenario) runRefundScenario(ctx context.Context, sess *userSession, reservation Reservation) error {
+	agent, user := sess.agent, sess.user
 
 	// Use parent context with timeout for login and waiting room
-	err = s.postLogin(ctx, agent, user)
+	err := s.postLogin(ctx, agent, user)
 	if err != nil {
 		return err
 	}
@@ -207,6 +224,7 @@ func (s *Scenario) runRefundScenario(ctx context.Context, user User, reservation
 	}()
 
 	// Request refund
+	s.beginRefund(reservation)
 	refundResp, err := s.requestRefund(ctx, agent, user, reservation.ReservationID)
 	if err != nil {
 		s.log.Error("Failed to request refund", err.Error(), "user", user.Name)
@@ -216,19 +234,10 @@ func (s *Scenario) runRefundScenario(ctx context.Context, user User, reservation
 	// Add refund amount if successful
 	if refundResp.Status == "success" {
 		s.log.Info("Refund request succeeded", "user", user.Name)
-		// Use random shard to reduce contention
-		shard := rand.Intn(32)
-		s.totalRefunds[shard].Add(int64(reservation.TotalPrice))
+		s.recordRefund(reservation)
 		s.log.Debug("Refund recorded", "amount", reservation.TotalPrice, "user", user.Name)
-
-		// Remove refunded reservations from tracking
-		for i := range reservation.Seats {
-			uniqueKey := fmt.Sprintf("%s_%d", reservation.ReservationID, i)
-			s.purchasedReservations.Delete(uniqueKey)
-		}
-		// Subtract refunded tickets from total tickets
-		s.totalTickets[shard].Add(-int64(len(reservation.Seats)))
 	} else {
+		s.recordRefundFailure(reservation)
 		return fmt.Errorf("refund request failed with error_code: %s", refundResp.ErrorCode)
 	}
 
@@ -239,13 +248,27 @@ func (s *Scenario) runRefundScenario(ctx context.Context, user User, reservation
 	return nil
 }
 
+// goRefund runs fn in a new goroutine tracked as an in-flight refund.
+func (s *Scenario) goRefund(fn func()) {
+	s.refundWg.Add(1)
+	s.inFlightRefunds.Add(1)
+	go func() {
+		defer s.refundWg.Done()
+		defer s.inFlightRefunds.Add(-1)
+		fn()
+	}()
+}
+
 func (s *Scenario) requestRefund(ctx context.Context, agent *agent.Agent, user User, reservationID string) (*RefundResp, error) {
 	reqBodyBuf, err := json.Marshal(RefundReq{ReservationID: reservationID})
 	if err != nil {
 		s.log.Error("Failed to parse JSON", "error", err.Error(), "user", user.Name)
 		return nil, err
 	}
+	s.updateTicketState(reservationID, ticketRefunding)
+	activity := s.sessions.begin(user.Name)
 	resp, err := HttpPost(ctx, agent, "/api/refund", bytes.NewReader(reqBodyBuf))
+	activity.end(err == nil && resp.StatusCode == 200)
 	if err != nil {
 		if ShouldLogHTTPError(ctx, err) {
 			s.log.Error("Failed to post /api/refund", "error", err.Error(), "user", user.Name)
@@ -259,6 +282,11 @@ func (s *Scenario) requestRefund(ctx context.Context, agent *agent.Agent, user U
 		s.log.Error("Failed to unmarshal response", "error", err.Error(), "user", user.Name)
 		return nil, err
 	}
+	if refundResp.Status == "success" {
+		s.updateTicketState(reservationID, ticketRefunded)
+	} else {
+		s.updateTicketState(reservationID, ticketPurchased)
+	}
 
 	return &refundResp, nil
 }
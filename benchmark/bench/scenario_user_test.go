@@ -1,15 +1,16 @@
 package bench
 
 import (
+	"math/rand"
+	"reflect"
 	"testing"
 )
 
 func TestGenerateRandomItinerary(t *testing.T) {
-	// Uncomment the line below to make the test deterministic
-	// rand.Seed(42)
+	rng := rand.New(rand.NewSource(42))
 
 	for i := 0; i < 10; i++ {
-		itinerary := generateRandomItinerary()
+		itinerary := generateRandomItinerary(rng, testLine(t))
 
 		// Test 1: Check the number of stations is between 2 and 5
 		if len(itinerary.Stations) < 2 || len(itinerary.Stations) > 5 {
@@ -24,3 +25,28 @@ func TestGenerateRandomItinerary(t *testing.T) {
 		}
 	}
 }
+
+func TestRandStreamIsDeterministic(t *testing.T) {
+	line := testLine(t)
+	for stream := streamUserWorkers; stream < streamUserWorkers+3; stream++ {
+		a := newRandStream(42, stream)
+		b := newRandStream(42, stream)
+		for i := 0; i < 10; i++ {
+			itineraryA := generateRandomItinerary(a, line)
+			itineraryB := generateRandomItinerary(b, line)
+			if !reflect.DeepEqual(itineraryA.Stations, itineraryB.Stations) {
+				t.Fatalf("stream %d generated different itineraries: %v, %v", stream, itineraryA.Stations, itineraryB.Stations)
+			}
+			if decideNumPeople(a, line, 10000, itineraryA) != decideNumPeople(b, line, 10000, itineraryB) {
+				t.Fatalf("stream %d decided different number of people", stream)
+			}
+		}
+	}
+
+	if newRandStream(42, streamUserWorkers).Int63() == newRandStream(42, streamUserWorkers+1).Int63() {
+		t.Error("adjacent streams generated the same value")
+	}
+	if newRandStream(42, streamAdmin).Int63() == newRandStream(43, streamAdmin).Int63() {
+		t.Error("different seeds generated the same value")
+	}
+}
@@ -0,0 +1,176 @@
+package bench
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Names of the scorers selectable by the profile or Config.Scorer
+const (
+	// ScorerSales scores the sales minus the refunds and the penalties of the violations
+	ScorerSales = "sales"
+	// ScorerLatencySLO also deducts points for each route whose p99 latency exceeds the SLO
+	ScorerLatencySLO = "latency_slo"
+	// ScorerErrorRate also deducts points for the rate of server errors, timeouts and connection errors
+	ScorerErrorRate = "error_rate"
+)
+
+// ScorerNames returns the names of all scorers.
+func ScorerNames() []string {
+	return []string{ScorerSales, ScorerLatencySLO, ScorerErrorRate}
+}
+
+// ScoreInput is the outcome of a run a Scorer scores.
+type ScoreInput struct {
+	Sales      int64
+	Purchased  int64
+	Refunds    int64
+	Violations []Violation
+	// Endpoints is the latency and outcome of the requests per route
+	Endpoints []EndpointStats
+}
+
+// ScoreTerm is a part of the score. Deductions have negative points.
+type ScoreTerm struct {
+	Name   string
+	Points float64
+	// Detail describes how the points were counted
+	Detail string
+}
+
+// ScoreResult is a score with the terms it is made of.
+type ScoreResult struct {
+	// Score is the total of the terms rounded down, and 0 if negative
+	Score int64
+	Terms []ScoreTerm
+}
+
+// Scorer turns the outcome of a run into a score.
+type Scorer interface {
+	Name() string
+	Score(in ScoreInput) ScoreResult
+}
+
+// NewScorer returns the scorer named name configured by profile. An empty name is the sales scorer.
+func NewScorer(name string, profile ScoringProfile) (Scorer, error) {
+	switch name {
+	case "", ScorerSales:
+		return salesScorer{}, nil
+	case ScorerLatencySLO:
+		return latencySLOScorer{slo: profile.LatencySLO}, nil
+	case ScorerErrorRate:
+		return errorRateScorer{limit: profile.ErrorRate}, nil
+	default:
+		return nil, fmt.Errorf("unknown scorer: %s (must be one of %s)", name, strings.Join(ScorerNames(), ", "))
+	}
+}
+
+// calculateScore returns the score from the sales of entered tickets, the price of purchased tickets and the refunds.
+// Purchased tickets which were not used count half.
+func calculateScore(sales, purchased, refunds int64) int64 {
+	return int64((float64(sales) + float64(purchased-sales)*0.5 - float64(refunds)) / 100)
+}
+
+// scoreSales scores the sales with deductions, each of which has whole negative points.
+// The penalties of the violations are deducted too, and a failing violation makes the score 0.
+func scoreSales(in ScoreInput, deductions ...ScoreTerm) ScoreResult {
+	unused := in.Purchased - in.Sales
+	terms := []ScoreTerm{
+		{Name: "sales", Points: float64(in.Sales) / 100, Detail: fmt.Sprintf("%d yen of entered tickets / 100", in.Sales)},
+		{Name: "unused_tickets", Points: float64(unused) * 0.5 / 100, Detail: fmt.Sprintf("%d yen of purchased tickets never entered / 200", unused)},
+		{Name: "refunds", Points: -float64(in.Refunds) / 100, Detail: fmt.Sprintf("%d yen of refunds / 100", in.Refunds)},
+	}
+	score := calculateScore(in.Sales, in.Purchased, in.Refunds)
+	for _, d := range deductions {
+		terms = append(terms, d)
+		score += int64(d.Points)
+	}
+
+	failed, penalty := summarizeViolations(in.Violations)
+	if penalty > 0 {
+		var penalized int
+		for _, v := range in.Violations {
+			if v.Rule().Penalty > 0 {
+				penalized++
+			}
+		}
+		terms = append(terms, ScoreTerm{Name: "violations", Points: -float64(penalty), Detail: fmt.Sprintf("penalty of %d violation(s)", penalized)})
+		score -= penalty
+	}
+	if failed != nil {
+		var total float64
+		for _, t := range terms {
+			total += t.Points
+		}
+		terms = append(terms, ScoreTerm{Name: "failed", Points: -total, Detail: fmt.Sprintf("%s fails the run", failed.Kind)})
+		score = 0
+	}
+	return ScoreResult{Score: max(score, 0), Terms: terms}
+}
+
+// salesScorer is the default scorer: (sales + unused purchased tickets / 2 - refunds) / 100.
+type salesScorer struct{}
+
+func (salesScorer) Name() string { return ScorerSales }
+
+func (salesScorer) Score(in ScoreInput) ScoreResult {
+	return scoreSales(in)
+}
+
+// latencySLOScorer deducts the penalty for each route whose p99 latency exceeds the SLO.
+type latencySLOScorer struct {
+	slo LatencySLOProfile
+}
+
+func (latencySLOScorer) Name() string { return ScorerLatencySLO }
+
+func (s latencySLOScorer) Score(in ScoreInput) ScoreResult {
+	var slow []string
+	for _, e := range in.Endpoints {
+		if e.Responses > 0 && e.P99 > s.slo.P99 {
+			slow = append(slow, fmt.Sprintf("%s %s (%s)", e.Method, e.Route, e.P99.Round(time.Millisecond)))
+		}
+	}
+	if len(slow) == 0 {
+		return scoreSales(in)
+	}
+	return scoreSales(in, ScoreTerm{
+		Name:   "latency_slo",
+		Points: -float64(int64(len(slow)) * s.slo.Penalty),
+		Detail: fmt.Sprintf("%d route(s) over p99 %s: %s", len(slow), s.slo.P99, strings.Join(slow, ", ")),
+	})
+}
+
+// errorRateScorer deducts the penalty for each percent of failed requests above the allowed rate.
+// Only server errors, timeouts and connection errors count, as the benchmark expects 4xx for invalid requests.
+type errorRateScorer struct {
+	limit ErrorRateProfile
+}
+
+func (errorRateScorer) Name() string { return ScorerErrorRate }
+
+func (s errorRateScorer) Score(in ScoreInput) ScoreResult {
+	var requests, failures int64
+	for _, e := range in.Endpoints {
+		requests += e.Requests()
+		failures += e.Timeouts + e.ConnectionErrors
+		for _, status := range e.ByStatus {
+			if status.StatusCode >= 500 {
+				failures += status.Count
+			}
+		}
+	}
+	if requests == 0 {
+		return scoreSales(in)
+	}
+	rate := float64(failures) * 100 / float64(requests)
+	if rate <= s.limit.MaxRate {
+		return scoreSales(in)
+	}
+	return scoreSales(in, ScoreTerm{
+		Name:   "error_rate",
+		Points: -float64(int64((rate - s.limit.MaxRate) * float64(s.limit.PenaltyPerPercent))),
+		Detail: fmt.Sprintf("%d of %d requests failed (%.2f%%, allowed %.2f%%)", failures, requests, rate, s.limit.MaxRate),
+	})
+}
@@ -0,0 +1,97 @@
+package bench
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSalesScorer(t *testing.T) {
+	scorer, err := NewScorer("", ScoringProfile{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if scorer.Name() != ScorerSales {
+		t.Errorf("expected the sales scorer by default, got %s", scorer.Name())
+	}
+
+	in := ScoreInput{Sales: 12000, Purchased: 15000, Refunds: 1000}
+	got := scorer.Score(in)
+	if want := calculateScore(in.Sales, in.Purchased, in.Refunds); got.Score != want {
+		t.Errorf("expected score %d, got %d", want, got.Score)
+	}
+	var total float64
+	for _, term := range got.Terms {
+		total += term.Points
+	}
+	if total != 125 {
+		t.Errorf("expected the terms to add up to 125, got %v in %+v", total, got.Terms)
+	}
+
+	in.Violations = []Violation{{Kind: ViolationPhantomTicket}, {Kind: ViolationPhantomTicket}}
+	if got := scorer.Score(in); got.Score != 105 {
+		t.Errorf("expected the penalties to be deducted, got %d", got.Score)
+	}
+
+	in.Violations = append(in.Violations, Violation{Kind: ViolationDoubleBooking})
+	got = scorer.Score(in)
+	if got.Score != 0 {
+		t.Errorf("expected a failing violation to make the score 0, got %d", got.Score)
+	}
+	if last := got.Terms[len(got.Terms)-1]; last.Name != "failed" || last.Points != -105 {
+		t.Errorf("expected the failed term to cancel the others, got %+v", last)
+	}
+}
+
+func TestLatencySLOScorer(t *testing.T) {
+	scorer, err := NewScorer(ScorerLatencySLO, ScoringProfile{LatencySLO: LatencySLOProfile{P99: time.Second, Penalty: 10}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	in := ScoreInput{
+		Sales:     10000,
+		Purchased: 10000,
+		Endpoints: []EndpointStats{
+			{Method: "GET", Route: "/api/schedules", Responses: 10, LatencyStats: LatencyStats{P99: 2 * time.Second}},
+			{Method: "POST", Route: "/api/reserve", Responses: 10, LatencyStats: LatencyStats{P99: 500 * time.Millisecond}},
+			{Method: "POST", Route: "/api/purchase", Timeouts: 3},
+		},
+	}
+	got := scorer.Score(in)
+	if got.Score != 90 {
+		t.Errorf("expected one route to be penalized, got %d", got.Score)
+	}
+	if last := got.Terms[len(got.Terms)-1]; last.Name != "latency_slo" || !strings.Contains(last.Detail, "GET /api/schedules") {
+		t.Errorf("unexpected latency term: %+v", last)
+	}
+}
+
+func TestErrorRateScorer(t *testing.T) {
+	scorer, err := NewScorer(ScorerErrorRate, ScoringProfile{ErrorRate: ErrorRateProfile{MaxRate: 1, PenaltyPerPercent: 10}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	in := ScoreInput{
+		Sales:     10000,
+		Purchased: 10000,
+		Endpoints: []EndpointStats{
+			{Responses: 90, Timeouts: 2, ByStatus: []StatusStats{{StatusCode: 200, Count: 80}, {StatusCode: 400, Count: 7}, {StatusCode: 503, Count: 3}}},
+			{Responses: 8},
+		},
+	}
+	// 5 of 100 requests failed, and 400s do not count
+	if got := scorer.Score(in); got.Score != 60 {
+		t.Errorf("expected 40 points for 4%% above the allowed rate, got %d %+v", got.Score, got.Terms)
+	}
+
+	in.Endpoints = []EndpointStats{{Responses: 100, ByStatus: []StatusStats{{StatusCode: 200, Count: 100}}}}
+	if got := scorer.Score(in); got.Score != 100 || len(got.Terms) != 3 {
+		t.Errorf("expected no deduction, got %d %+v", got.Score, got.Terms)
+	}
+}
+
+func TestNewScorerUnknown(t *testing.T) {
+	if _, err := NewScorer("fastest", ScoringProfile{}); err == nil {
+		t.Error("expected an error for an unknown scorer")
+	}
+}
@@ -0,0 +1,169 @@
+package bench
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// seatClaim is a purchased reservation occupying a seat for a section.
+type seatClaim struct {
+	ReservationID string
+	User          string
+	PurchasedAt   time.Time
+}
+
+// seatConflict is a seat sold to two reservations for the same section.
+type seatConflict struct {
+	ScheduleID string
+	Seat       string
+	Section    string // e.g., "A->B"
+	First      seatClaim
+	Second     seatClaim
+}
+
+func (c seatConflict) Error() string {
+	return fmt.Sprintf("double booking detected: schedule %s, seat %s, section %s is sold to reservation %s (user %s at %s) and reservation %s (user %s at %s)",
+		c.ScheduleID, c.Seat, c.Section,
+		c.First.ReservationID, c.First.User, c.First.PurchasedAt.In(jst).Format("15:04:05.000"),
+		c.Second.ReservationID, c.Second.User, c.Second.PurchasedAt.In(jst).Format("15:04:05.000"))
+}
+
+// deferredClaim is a claim on a seat of a reservation whose refund was pending. The application may free
+// the seats before it responds to the refund, so the claim is decided once the outcome of the refund is known.
+type deferredClaim struct {
+	key        string
+	scheduleID string
+	seat       string
+	section    string
+	claim      seatClaim
+}
+
+// seatIndex tracks which reservation occupies each seat for each section, and detects double booking on purchase.
+type seatIndex struct {
+	line      *Line
+	mu        sync.Mutex
+	claims    map[string]seatClaim // key: "ScheduleID|Seat|Section" (e.g., "E2123|A-3|A->B")
+	conflicts []seatConflict
+	// refunding holds the claims deferred per reservation whose refund is pending
+	refunding map[string][]deferredClaim
+}
+
+func newSeatIndex(line *Line) *seatIndex {
+	return &seatIndex{line: line, claims: make(map[string]seatClaim), refunding: make(map[string][]deferredClaim)}
+}
+
+func seatIndexKey(scheduleID, seat, section string) string {
+	return scheduleID + "|" + seat + "|" + section
+}
+
+// reservationSections returns the sections covered by the reservation (e.g., Arena -> Cave becomes ["A->B", "B->C"]).
+func (i *seatIndex) reservationSections(reservation Reservation) []string {
+	return i.line.sections(i.line.stationID(reservation.FromStation), i.line.stationID(reservation.ToStation))
+}
+
+// claim occupies the seats of a purchased reservation and returns the conflicts with other reservations.
+// Seats already occupied by another reservation are kept by the first one.
+func (i *seatIndex) claim(reservation Reservation, user string, purchasedAt time.Time) []seatConflict {
+	claim := seatClaim{ReservationID: reservation.ReservationID, User: user, PurchasedAt: purchasedAt}
+	sections := i.reservationSections(reservation)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var conflicts []seatConflict
+	for _, seat := range reservation.Seats {
+		for _, section := range sections {
+			key := seatIndexKey(reservation.ScheduleID, seat, section)
+			existing, ok := i.claims[key]
+			if !ok {
+				i.claims[key] = claim
+				continue
+			}
+			if existing.ReservationID == claim.ReservationID {
+				continue
+			}
+			if deferred, refunding := i.refunding[existing.ReservationID]; refunding {
+				i.refunding[existing.ReservationID] = append(deferred, deferredClaim{
+					key:        key,
+					scheduleID: reservation.ScheduleID,
+					seat:       seat,
+					section:    section,
+					claim:      claim,
+				})
+				continue
+			}
+			conflicts = append(conflicts, seatConflict{
+				ScheduleID: reservation.ScheduleID,
+				Seat:       seat,
+				Section:    section,
+				First:      existing,
+				Second:     claim,
+			})
+		}
+	}
+	i.conflicts = append(i.conflicts, conflicts...)
+	return conflicts
+}
+
+// beginRefund marks the reservation as refund pending before the refund is requested.
+// Until endRefund, other reservations claiming its seats are not double bookings yet.
+func (i *seatIndex) beginRefund(reservation Reservation) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.refunding[reservation.ReservationID]; !ok {
+		i.refunding[reservation.ReservationID] = nil
+	}
+}
+
+// endRefund decides the claims deferred by the refund of the reservation and returns the conflicts among them.
+// The seats of a refunded reservation go to the claims made while the refund was pending.
+// If the refund failed, the reservation keeps its seats and those claims are double bookings.
+// A refund whose outcome is unknown is left pending, so its seats are never reported.
+func (i *seatIndex) endRefund(reservation Reservation, refunded bool) []seatConflict {
+	sections := i.reservationSections(reservation)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	deferred := i.refunding[reservation.ReservationID]
+	delete(i.refunding, reservation.ReservationID)
+	if refunded {
+		for _, seat := range reservation.Seats {
+			for _, section := range sections {
+				key := seatIndexKey(reservation.ScheduleID, seat, section)
+				if existing, ok := i.claims[key]; ok && existing.ReservationID == reservation.ReservationID {
+					delete(i.claims, key)
+				}
+			}
+		}
+	}
+
+	var conflicts []seatConflict
+	for _, d := range deferred {
+		existing, ok := i.claims[d.key]
+		if !ok {
+			i.claims[d.key] = d.claim
+			continue
+		}
+		if existing.ReservationID == d.claim.ReservationID {
+			continue
+		}
+		conflicts = append(conflicts, seatConflict{
+			ScheduleID: d.scheduleID,
+			Seat:       d.seat,
+			Section:    d.section,
+			First:      existing,
+			Second:     d.claim,
+		})
+	}
+	i.conflicts = append(i.conflicts, conflicts...)
+	return conflicts
+}
+
+// allConflicts returns every conflict detected so far.
+func (i *seatIndex) allConflicts() []seatConflict {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]seatConflict(nil), i.conflicts...)
+}
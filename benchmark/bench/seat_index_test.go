@@ -0,0 +1,95 @@
+package bench
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSeatIndex(t *testing.T) {
+	index := newSeatIndex(testLine(t))
+	now := time.Now()
+
+	first := Reservation{ReservationID: "r1", ScheduleID: "E5001-1", FromStation: "Arena", ToStation: "Cave", Seats: []string{"1-A", "1-B"}}
+	if conflicts := index.claim(first, "alice", now); len(conflicts) != 0 {
+		t.Fatalf("unexpected conflicts: %v", conflicts)
+	}
+
+	// Other sections of the same seat are free
+	other := Reservation{ReservationID: "r2", ScheduleID: "E5001-1", FromStation: "Cave", ToStation: "Edge", Seats: []string{"1-A"}}
+	if conflicts := index.claim(other, "bob", now); len(conflicts) != 0 {
+		t.Fatalf("unexpected conflicts: %v", conflicts)
+	}
+
+	// Bridge -> Cave overlaps with Arena -> Cave on 1-B
+	overlap := Reservation{ReservationID: "r3", ScheduleID: "E5001-1", FromStation: "Bridge", ToStation: "Cave", Seats: []string{"1-B"}}
+	conflicts := index.claim(overlap, "carol", now.Add(time.Second))
+	if len(conflicts) != 1 {
+		t.Fatalf("expected 1 conflict, got %v", conflicts)
+	}
+	c := conflicts[0]
+	if c.Section != "B->C" || c.First.ReservationID != "r1" || c.Second.ReservationID != "r3" || c.Second.User != "carol" {
+		t.Errorf("unexpected conflict: %+v", c)
+	}
+	if msg := c.Error(); !strings.Contains(msg, "r1 (user alice") || !strings.Contains(msg, "r3 (user carol") {
+		t.Errorf("conflict message does not name both reservations: %s", msg)
+	}
+
+	// The seats are free again after the refund
+	index.beginRefund(first)
+	if conflicts := index.endRefund(first, true); len(conflicts) != 0 {
+		t.Fatalf("unexpected conflicts on refund: %v", conflicts)
+	}
+	if conflicts := index.claim(overlap, "carol", now); len(conflicts) != 0 {
+		t.Errorf("unexpected conflicts after refund: %v", conflicts)
+	}
+	if got := len(index.allConflicts()); got != 1 {
+		t.Errorf("expected 1 conflict in total, got %d", got)
+	}
+}
+
+func TestSeatIndexPendingRefund(t *testing.T) {
+	index := newSeatIndex(testLine(t))
+	now := time.Now()
+
+	refunded := Reservation{ReservationID: "r1", ScheduleID: "E5001-1", FromStation: "Arena", ToStation: "Cave", Seats: []string{"1-A"}}
+	index.claim(refunded, "alice", now)
+
+	// The application frees the seat while it handles the refund, so bob buys it before the refund responds
+	index.beginRefund(refunded)
+	rebought := Reservation{ReservationID: "r2", ScheduleID: "E5001-1", FromStation: "Bridge", ToStation: "Cave", Seats: []string{"1-A"}}
+	if conflicts := index.claim(rebought, "bob", now.Add(time.Second)); len(conflicts) != 0 {
+		t.Fatalf("expected no conflict with a pending refund, got %v", conflicts)
+	}
+	if conflicts := index.endRefund(refunded, true); len(conflicts) != 0 {
+		t.Fatalf("expected no conflict after the refund, got %v", conflicts)
+	}
+
+	// bob holds the seat now
+	third := Reservation{ReservationID: "r3", ScheduleID: "E5001-1", FromStation: "Bridge", ToStation: "Cave", Seats: []string{"1-A"}}
+	if conflicts := index.claim(third, "carol", now.Add(2*time.Second)); len(conflicts) != 1 || conflicts[0].First.ReservationID != "r2" {
+		t.Errorf("expected a conflict with bob's reservation, got %v", conflicts)
+	}
+}
+
+func TestSeatIndexFailedRefund(t *testing.T) {
+	index := newSeatIndex(testLine(t))
+	now := time.Now()
+
+	kept := Reservation{ReservationID: "r1", ScheduleID: "E5001-1", FromStation: "Arena", ToStation: "Bridge", Seats: []string{"1-A"}}
+	index.claim(kept, "alice", now)
+	index.beginRefund(kept)
+	sold := Reservation{ReservationID: "r2", ScheduleID: "E5001-1", FromStation: "Arena", ToStation: "Bridge", Seats: []string{"1-A"}}
+	if conflicts := index.claim(sold, "bob", now); len(conflicts) != 0 {
+		t.Fatalf("expected no conflict with a pending refund, got %v", conflicts)
+	}
+
+	// The refund was refused, so the seat was sold twice after all
+	conflicts := index.endRefund(kept, false)
+	if len(conflicts) != 1 || conflicts[0].First.ReservationID != "r1" || conflicts[0].Second.ReservationID != "r2" {
+		t.Fatalf("expected a double booking once the refund failed, got %v", conflicts)
+	}
+	if got := len(index.allConflicts()); got != 1 {
+		t.Errorf("expected 1 conflict in total, got %d", got)
+	}
+}
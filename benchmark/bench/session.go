@@ -0,0 +1,296 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/isucon/isucandar/agent"
+)
+
+// The reference app expires a session after 10 seconds without activity
+const sessionIdleTimeout = 10 * time.Second
+
+// Margin for the clocks and the precision of the last activity stored by the application
+const sessionIdleTolerance = 2 * time.Second
+
+// Probability that a user logs out after buying tickets instead of leaving the session idle
+const logoutRate = 0.2
+
+// sessionState is the activity of a user as seen by the benchmark.
+// The application updates the last activity while handling a request, so the benchmark only knows
+// it happened between the start and the end of the request.
+type sessionState struct {
+	certainStart time.Time // start of the latest request which surely updated the last activity
+	certainEnd   time.Time // end of the same request
+	possibleEnd  time.Time // end of the latest request which may have updated the last activity
+	inFlight     int       // requests which may update the last activity without response yet
+	generation   int64     // incremented by every request and logout
+	loggedOutAt  time.Time
+	// certainStart and certainEnd of the request before, to know the activity while the latest one was in flight
+	previousCertainStart time.Time
+	previousCertainEnd   time.Time
+}
+
+// loggedOut reports whether the user logged out after the last activity.
+func (st sessionState) loggedOut() bool {
+	return st.inFlight == 0 && !st.loggedOutAt.IsZero() && st.loggedOutAt.After(st.possibleEnd)
+}
+
+// mayBeActive reports whether the application may count the user as active at a time after `since`.
+func (st sessionState) mayBeActive(since time.Time) bool {
+	return st.inFlight > 0 || !st.possibleEnd.Before(since.Add(-sessionIdleTimeout-sessionIdleTolerance))
+}
+
+// surelyActive reports whether the application must count the user as active at `at`.
+// Only requests whose response was received by `at` count, since the application may not have handled
+// the others yet. A user who logged out is not, since the application should stop counting the user.
+func (st sessionState) surelyActive(at time.Time) bool {
+	start := st.certainStart
+	if st.certainEnd.After(at) {
+		if st.previousCertainEnd.After(at) {
+			return false
+		}
+		start = st.previousCertainStart
+	}
+	if !st.loggedOutAt.IsZero() && st.loggedOutAt.After(start) {
+		return false
+	}
+	return !start.IsZero() && !start.Before(at.Add(-sessionIdleTimeout+sessionIdleTolerance))
+}
+
+// sessionTracker follows the activity of every user, so the session and waiting room responses can be checked.
+type sessionTracker struct {
+	// maxActiveUsers is the number of users the waiting room lets in. The waiting room is not checked if 0.
+	maxActiveUsers int
+	mu             sync.Mutex
+	users          map[string]*sessionState
+	// untracked is the number of users of the benchmark which may be active without being tracked (e.g., the adversary)
+	untracked int
+	reported  map[ViolationKind]bool
+}
+
+func newSessionTracker(maxActiveUsers int) *sessionTracker {
+	return &sessionTracker{
+		maxActiveUsers: maxActiveUsers,
+		users:          make(map[string]*sessionState),
+		reported:       make(map[ViolationKind]bool),
+	}
+}
+
+// addUntrackedUser counts a user whose activity is not tracked. It is never counted as surely active,
+// but always as possibly active.
+func (t *sessionTracker) addUntrackedUser() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.untracked++
+}
+
+// sessionActivity is a request which may update the last activity of a user.
+type sessionActivity struct {
+	tracker *sessionTracker
+	name    string
+	start   time.Time
+}
+
+// begin marks the start of a request which may update the last activity of the user.
+func (t *sessionTracker) begin(name string) sessionActivity {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.state(name)
+	st.inFlight++
+	st.generation++
+	return sessionActivity{tracker: t, name: name, start: time.Now()}
+}
+
+// end marks the end of the request. updated is true if the response proves the last activity was updated.
+func (a sessionActivity) end(updated bool) {
+	t := a.tracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.state(a.name)
+	st.inFlight--
+	st.possibleEnd = time.Now()
+	if updated && a.start.After(st.certainStart) {
+		st.previousCertainStart, st.previousCertainEnd = st.certainStart, st.certainEnd
+		st.certainStart, st.certainEnd = a.start, st.possibleEnd
+	}
+}
+
+// logout records that the user logged out at `at`.
+func (t *sessionTracker) logout(name string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.state(name)
+	st.loggedOutAt = at
+	st.generation++
+}
+
+func (t *sessionTracker) snapshot(name string) sessionState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return *t.state(name)
+}
+
+func (t *sessionTracker) state(name string) *sessionState {
+	st, ok := t.users[name]
+	if !ok {
+		st = &sessionState{}
+		t.users[name] = st
+	}
+	return st
+}
+
+// firstReport reports whether a violation of kind is found for the first time.
+// Some findings repeat for every session, so they are listed once.
+func (t *sessionTracker) firstReport(kind ViolationKind) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.reported[kind] {
+		return false
+	}
+	t.reported[kind] = true
+	return true
+}
+
+// checkSessionStatus checks a status of /api/session requested between start and end against the activity of the user.
+// before and after are the states of the user at start and end. The check is skipped if the user was active meanwhile.
+func checkSessionStatus(name, status string, before, after sessionState, start, end time.Time) (Violation, bool) {
+	if before.generation != after.generation || after.inFlight > 0 || after.possibleEnd.IsZero() {
+		return Violation{}, false
+	}
+	switch status {
+	case "session_expired":
+		idle := end.Sub(after.certainStart)
+		if after.certainStart.IsZero() || idle >= sessionIdleTimeout-sessionIdleTolerance {
+			return Violation{}, false
+		}
+		return Violation{
+			Kind:    ViolationSessionExpiredEarly,
+			Message: fmt.Sprintf("session of %s expired after %s without activity, but the idle timeout is %s", name, idle.Round(time.Millisecond), sessionIdleTimeout),
+			Details: map[string]string{"user": name, "idle_ms": strconv.FormatInt(idle.Milliseconds(), 10)},
+		}, true
+	case "active":
+		idle := start.Sub(after.possibleEnd)
+		if idle <= sessionIdleTimeout+sessionIdleTolerance {
+			return Violation{}, false
+		}
+		return Violation{
+			Kind:    ViolationSessionNotExpired,
+			Message: fmt.Sprintf("session of %s is still active after %s without activity, but the idle timeout is %s", name, idle.Round(time.Millisecond), sessionIdleTimeout),
+			Details: map[string]string{"user": name, "idle_ms": strconv.FormatInt(idle.Milliseconds(), 10)},
+		}, true
+	}
+	return Violation{}, false
+}
+
+// checkWaiting checks a "waiting" status of /api/waiting_status requested at start. Call it right after the response.
+// It reports when the room could only be full if users who logged out were still counted as active.
+func (t *sessionTracker) checkWaiting(start time.Time) (Violation, bool) {
+	if t.maxActiveUsers == 0 {
+		return Violation{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	withLoggedOut, withoutLoggedOut := t.untracked, t.untracked
+	for _, st := range t.users {
+		if !st.mayBeActive(start) {
+			continue
+		}
+		withLoggedOut++
+		if !st.loggedOut() {
+			withoutLoggedOut++
+		}
+	}
+	if withoutLoggedOut >= t.maxActiveUsers || withLoggedOut < t.maxActiveUsers {
+		return Violation{}, false
+	}
+	return Violation{
+		Kind: ViolationLoggedOutUserCounted,
+		Message: fmt.Sprintf("waiting room is full, but only %d users may be active besides %d users who logged out",
+			withoutLoggedOut, withLoggedOut-withoutLoggedOut),
+		Details: map[string]string{
+			"active_users":     strconv.Itoa(withoutLoggedOut),
+			"logged_out_users": strconv.Itoa(withLoggedOut - withoutLoggedOut),
+		},
+	}, true
+}
+
+// surelyActiveUsers returns the number of users the application must count as active at `at`.
+func (t *sessionTracker) surelyActiveUsers(at time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	count := 0
+	for _, st := range t.users {
+		if st.surelyActive(at) {
+			count++
+		}
+	}
+	return count
+}
+
+// recordSessionViolation records a violation of the session lifecycle. once lists it only the first time.
+func (s *Scenario) recordSessionViolation(v Violation, once bool, user User) {
+	if once && !s.sessions.firstReport(v.Kind) {
+		return
+	}
+	s.log.Warn("Session lifecycle violation", "error", v.Message, "user", user.Name)
+	s.violations.add(v)
+}
+
+// logoutAndVerify logs the user out and checks the application no longer accepts the cookie of the session.
+func (s *Scenario) logoutAndVerify(ctx context.Context, agent *agent.Agent, user User) error {
+	oldCookies := agent.HttpClient.Jar.Cookies(agent.BaseURL)
+
+	resp, err := HttpPost(ctx, agent, "/api/logout", nil)
+	if err != nil {
+		return fmt.Errorf("failed to post /api/logout: %w", err)
+	}
+	s.log.Info("POST /api/logout", "statusCode", resp.StatusCode, "user", user.Name)
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("got %d status code from /api/logout", resp.StatusCode)
+	}
+	s.sessions.logout(user.Name, time.Now())
+
+	accepted, err := s.sessionAcceptsCookies(ctx, oldCookies)
+	if err != nil {
+		return err
+	}
+	if accepted {
+		s.recordSessionViolation(Violation{
+			Kind:    ViolationSessionAfterLogout,
+			Message: fmt.Sprintf("/api/session accepted the cookie of %s after logout", user.Name),
+			Details: map[string]string{"user": user.Name},
+		}, true, user)
+	}
+	return nil
+}
+
+// sessionAcceptsCookies reports whether /api/session treats a request with cookies as an active session.
+// /api/session does not update the last activity, so it does not keep the session alive.
+func (s *Scenario) sessionAcceptsCookies(ctx context.Context, cookies []*http.Cookie) (bool, error) {
+	probe, err := s.newAgent()
+	if err != nil {
+		return false, fmt.Errorf("failed to create agent: %w", err)
+	}
+	defer probe.HttpClient.CloseIdleConnections()
+	probe.HttpClient.Jar.SetCookies(probe.BaseURL, cookies)
+
+	resp, err := HttpGet(ctx, probe, "/api/session")
+	if err != nil {
+		return false, fmt.Errorf("failed to get /api/session: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		return false, nil
+	}
+	var session SessionResp
+	if err := json.Unmarshal(resp.Body, &session); err != nil {
+		return false, fmt.Errorf("failed to unmarshal /api/session response: %w", err)
+	}
+	return session.Status == "active", nil
+}
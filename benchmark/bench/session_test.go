@@ -0,0 +1,166 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/isucon/isucandar/agent"
+	"github.com/showwin/ISHOCON3/benchmark/bench/logger"
+)
+
+func TestCheckSessionStatus(t *testing.T) {
+	now := time.Now()
+	idleFor := func(d time.Duration) sessionState {
+		return sessionState{certainStart: now.Add(-d - 100*time.Millisecond), possibleEnd: now.Add(-d), generation: 1}
+	}
+	tests := []struct {
+		name   string
+		status string
+		before sessionState
+		after  sessionState
+		want   ViolationKind
+	}{
+		{"expired after the timeout", "session_expired", idleFor(11 * time.Second), idleFor(11 * time.Second), ""},
+		{"expired early", "session_expired", idleFor(3 * time.Second), idleFor(3 * time.Second), ViolationSessionExpiredEarly},
+		{"active before the timeout", "active", idleFor(3 * time.Second), idleFor(3 * time.Second), ""},
+		{"active after the timeout", "active", idleFor(20 * time.Second), idleFor(20 * time.Second), ViolationSessionNotExpired},
+		{"active while another request is running", "active", idleFor(20 * time.Second), sessionState{possibleEnd: now.Add(-20 * time.Second), inFlight: 1, generation: 2}, ""},
+		{"expired after a request of another session", "session_expired", idleFor(20 * time.Second), sessionState{certainStart: now.Add(-3 * time.Second), possibleEnd: now.Add(-3 * time.Second), generation: 2}, ""},
+		{"never active", "session_expired", sessionState{}, sessionState{}, ""},
+	}
+	for _, tt := range tests {
+		v, ok := checkSessionStatus("alice", tt.status, tt.before, tt.after, now, now)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("%s: expected no violation, got %+v", tt.name, v)
+			}
+			continue
+		}
+		if !ok || v.Kind != tt.want {
+			t.Errorf("%s: expected %s, got %+v", tt.name, tt.want, v)
+		}
+	}
+}
+
+func TestCheckWaiting(t *testing.T) {
+	tracker := newSessionTracker(5)
+	for _, name := range []string{"u1", "u2", "u3", "u4", "u5"} {
+		tracker.begin(name).end(true)
+	}
+	if _, ok := tracker.checkWaiting(time.Now()); ok {
+		t.Error("expected no violation while 5 users are active")
+	}
+
+	tracker.logout("u5", time.Now())
+	v, ok := tracker.checkWaiting(time.Now())
+	if !ok || v.Kind != ViolationLoggedOutUserCounted {
+		t.Errorf("expected %s, got %+v", ViolationLoggedOutUserCounted, v)
+	}
+
+	// Logging in again makes the user active
+	tracker.begin("u5").end(true)
+	if _, ok := tracker.checkWaiting(time.Now()); ok {
+		t.Error("expected no violation after logging in again")
+	}
+
+	// Idle users do not count
+	if _, ok := tracker.checkWaiting(time.Now().Add(time.Minute)); ok {
+		t.Error("expected no violation when the users are idle")
+	}
+}
+
+func TestCheckWaitingUntrackedUser(t *testing.T) {
+	tracker := newSessionTracker(5)
+	for _, name := range []string{"u1", "u2", "u3", "u4", "u5"} {
+		tracker.begin(name).end(true)
+	}
+	tracker.logout("u5", time.Now())
+	// The untracked user may be the fifth active user
+	tracker.addUntrackedUser()
+	if v, ok := tracker.checkWaiting(time.Now()); ok {
+		t.Errorf("expected no violation with an untracked user, got %+v", v)
+	}
+}
+
+func newSessionTestScenario(t *testing.T, handler http.HandlerFunc) *Scenario {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return &Scenario{
+		targetURL:   server.URL,
+		log:         logger.GetLogger("error"),
+		violations:  &violationRecorder{},
+		sessions:    newSessionTracker(5),
+		connections: newConnectionPool(HTTPProfile{}, newRequestRecorder()),
+	}
+}
+
+func TestLogoutAndVerify(t *testing.T) {
+	tests := []struct {
+		name          string
+		accepted      bool
+		wantViolation bool
+	}{
+		{"cookie accepted", true, true},
+		{"cookie rejected", false, false},
+	}
+	for _, tt := range tests {
+		s := newSessionTestScenario(t, func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/logout":
+				http.SetCookie(w, &http.Cookie{Name: "user_name", Value: "", MaxAge: -1})
+				json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+			case "/api/session":
+				if cookie, err := r.Cookie("user_name"); !tt.accepted || err != nil || cookie.Value != "alice" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				json.NewEncoder(w).Encode(SessionResp{Status: "active", NextCheck: 500})
+			}
+		})
+		a, err := agent.NewAgent(agent.WithBaseURL(s.targetURL), agent.WithDefaultTransport())
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.HttpClient.Jar.SetCookies(a.BaseURL, []*http.Cookie{{Name: "user_name", Value: "alice"}})
+
+		// Logouts are flagged only once
+		for i := 0; i < 2; i++ {
+			if err := s.logoutAndVerify(context.Background(), a, User{Name: "alice"}); err != nil {
+				t.Fatalf("%s: unexpected error: %v", tt.name, err)
+			}
+		}
+		violations := s.violations.all()
+		if tt.wantViolation && (len(violations) != 1 || violations[0].Kind != ViolationSessionAfterLogout) {
+			t.Errorf("%s: expected a %s, got %+v", tt.name, ViolationSessionAfterLogout, violations)
+		}
+		if !tt.wantViolation && len(violations) != 0 {
+			t.Errorf("%s: expected no violations, got %+v", tt.name, violations)
+		}
+		if !s.sessions.snapshot("alice").loggedOut() {
+			t.Errorf("%s: expected alice to be logged out", tt.name)
+		}
+	}
+}
+
+func TestCheckSessionUnknownStatus(t *testing.T) {
+	s := newSessionTestScenario(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(SessionResp{Status: "sleeping", NextCheck: 500})
+	})
+	a, err := agent.NewAgent(agent.WithBaseURL(s.targetURL), agent.WithDefaultTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.checkSession(context.Background(), a, User{Name: "alice"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	violations := s.violations.all()
+	if len(violations) != 1 || violations[0].Kind != ViolationUnknownSessionStatus || violations[0].Rule().Penalty == 0 {
+		t.Errorf("expected a penalized %s, got %+v", ViolationUnknownSessionStatus, violations)
+	}
+}
@@ -0,0 +1,76 @@
+package bench
+
+import (
+	"strings"
+)
+
+// trainModel is the seat layout and the seat class of a train model.
+type trainModel struct {
+	Name        string
+	SeatRows    int
+	SeatColumns int
+}
+
+func (m trainModel) capacity() int {
+	return m.SeatRows * m.SeatColumns
+}
+
+// Seat layouts of the train models, as defined in webapp/sql/02-data.sql.
+// /api/train_models only returns the model names.
+var knownTrainModels = []trainModel{
+	{Name: "Economy-5", SeatRows: 10, SeatColumns: 5},
+	{Name: "Economy-4", SeatRows: 10, SeatColumns: 4},
+	{Name: "Business-4", SeatRows: 7, SeatColumns: 4},
+	{Name: "First-3", SeatRows: 5, SeatColumns: 3},
+	{Name: "Luxury-2", SeatRows: 2, SeatColumns: 2},
+}
+
+// trainModelPrefix returns the prefix of the names of the trains of the model (e.g., "Business-4" -> "B4").
+func trainModelPrefix(modelName string) string {
+	parts := strings.Split(modelName, "-")
+	for i := range parts {
+		if parts[i] != "" {
+			parts[i] = string(parts[i][0])
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// trainModelTable finds the model of a train from its name.
+type trainModelTable struct {
+	byPrefix map[string]trainModel
+}
+
+// newTrainModelTable returns the table of the models in modelNames whose seat layout is known.
+// The names of the other models are returned as unknown.
+func newTrainModelTable(modelNames []string) (*trainModelTable, []string) {
+	layouts := make(map[string]trainModel, len(knownTrainModels))
+	for _, model := range knownTrainModels {
+		layouts[model.Name] = model
+	}
+
+	table := &trainModelTable{byPrefix: make(map[string]trainModel, len(modelNames))}
+	var unknown []string
+	for _, name := range modelNames {
+		model, ok := layouts[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		table.byPrefix[trainModelPrefix(name)] = model
+	}
+	return table, unknown
+}
+
+// modelOf returns the model of the train (e.g., "E5001" -> Economy-5).
+func (t *trainModelTable) modelOf(trainName string) (trainModel, bool) {
+	var found trainModel
+	longest := 0
+	for prefix, model := range t.byPrefix {
+		if len(prefix) > longest && strings.HasPrefix(trainName, prefix) {
+			found = model
+			longest = len(prefix)
+		}
+	}
+	return found, longest > 0
+}
@@ -0,0 +1,29 @@
+package bench
+
+import "testing"
+
+func TestTrainModelTable(t *testing.T) {
+	table, unknown := newTrainModelTable([]string{"Economy-5", "Luxury-2", "Sleeper-9"})
+	if len(unknown) != 1 || unknown[0] != "Sleeper-9" {
+		t.Errorf("unexpected unknown models: %v", unknown)
+	}
+
+	tests := []struct {
+		trainName string
+		want      string
+		capacity  int
+	}{
+		{"E5001", "Economy-5", 50},
+		{"L2HK3", "Luxury-2", 4},
+	}
+	for _, tt := range tests {
+		model, ok := table.modelOf(tt.trainName)
+		if !ok || model.Name != tt.want || model.capacity() != tt.capacity {
+			t.Errorf("modelOf(%s) = %+v, %v, want %s with %d seats", tt.trainName, model, ok, tt.want, tt.capacity)
+		}
+	}
+	// Economy-4 is not in the table
+	if model, ok := table.modelOf("E4001"); ok {
+		t.Errorf("modelOf(E4001) = %+v, want not found", model)
+	}
+}
@@ -0,0 +1,136 @@
+package bench
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/isucon/isucandar/agent"
+)
+
+// requestTimeout is the timeout of every request to the application
+const requestTimeout = 10 * time.Second
+
+// ConnectionStats is how many TCP connections the benchmark opened to the application.
+type ConnectionStats struct {
+	// Sessions is the number of user sessions, each with its own connection pool
+	Sessions int64
+	Opened   int64
+	// PeakOpen is the most connections open at the same time
+	PeakOpen int64
+}
+
+// connectionPool creates the transports of the benchmark and counts the connections they open.
+type connectionPool struct {
+	profile  HTTPProfile
+	requests *requestRecorder
+	dialer   *net.Dialer
+	sessions atomic.Int64
+	opened   atomic.Int64
+	open     atomic.Int64
+	peak     atomic.Int64
+}
+
+func newConnectionPool(profile HTTPProfile, requests *requestRecorder) *connectionPool {
+	return &connectionPool{
+		profile:  profile,
+		requests: requests,
+		dialer:   &net.Dialer{KeepAlive: 60 * time.Second},
+	}
+}
+
+// newAgent returns an agent with its own cookie jar and connection pool, recording its requests to the pool's recorder.
+func (p *connectionPool) newAgent(targetURL string) (*agent.Agent, error) {
+	transport := agent.DefaultTransport.Clone()
+	transport.Dial = nil
+	transport.DialContext = p.dialContext
+	transport.MaxConnsPerHost = p.profile.MaxConnsPerUser
+	transport.MaxIdleConnsPerHost = p.profile.MaxIdleConnsPerUser
+	transport.IdleConnTimeout = p.profile.IdleConnTimeout
+	a, err := agent.NewAgent(agent.WithBaseURL(targetURL), agent.WithTransport(transport))
+	if err != nil {
+		return nil, err
+	}
+	// The request timeout is enforced by the transport
+	a.HttpClient.Timeout = 0
+	a.HttpClient.Transport = &recordingTransport{Transport: transport, requests: p.requests, timeout: requestTimeout}
+	return a, nil
+}
+
+func (p *connectionPool) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := p.dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	p.opened.Add(1)
+	open := p.open.Add(1)
+	for {
+		peak := p.peak.Load()
+		if open <= peak || p.peak.CompareAndSwap(peak, open) {
+			break
+		}
+	}
+	return &countedConn{Conn: conn, pool: p}, nil
+}
+
+func (p *connectionPool) stats() ConnectionStats {
+	return ConnectionStats{
+		Sessions: p.sessions.Load(),
+		Opened:   p.opened.Load(),
+		PeakOpen: p.peak.Load(),
+	}
+}
+
+// countedConn is a connection counted as open until it is closed.
+type countedConn struct {
+	net.Conn
+	pool      *connectionPool
+	closeOnce sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.pool.open.Add(-1)
+	})
+	return c.Conn.Close()
+}
+
+// newAgent returns an agent for requests which do not belong to the journey of a user.
+func (s *Scenario) newAgent() (*agent.Agent, error) {
+	return s.connections.newAgent(s.targetURL)
+}
+
+// userSession is the browser of a user. Its agent keeps the cookie jar and the connections
+// through the whole journey from login to refund, so requests do not open new connections.
+type userSession struct {
+	user  User
+	agent *agent.Agent
+	refs  atomic.Int64
+}
+
+// newUserSession returns a session held by the caller until release.
+func (s *Scenario) newUserSession(user User) (*userSession, error) {
+	agent, err := s.newAgent()
+	if err != nil {
+		return nil, err
+	}
+	s.connections.sessions.Add(1)
+	sess := &userSession{user: user, agent: agent}
+	sess.refs.Store(1)
+	return sess, nil
+}
+
+// acquire holds the session until the matching release.
+// Entries and refunds outlive the scenario which logged in, so they hold the session until they finish.
+func (u *userSession) acquire() {
+	u.refs.Add(1)
+}
+
+// release closes the idle connections of the session once nothing holds it anymore.
+func (u *userSession) release() {
+	if u.refs.Add(-1) == 0 {
+		u.agent.HttpClient.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,55 @@
+package bench
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUserSessionReusesConnections(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/login" {
+			http.SetCookie(w, &http.Cookie{Name: "user_name", Value: "alice"})
+			return
+		}
+		if cookie, err := r.Cookie("user_name"); err != nil || cookie.Value != "alice" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	s := &Scenario{targetURL: server.URL, connections: newConnectionPool(HTTPProfile{MaxConnsPerUser: 1}, newRequestRecorder())}
+	sess, err := s.newUserSession(User{Name: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := HttpPost(context.Background(), sess.agent, "/api/login", nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		resp, err := HttpGet(context.Background(), sess.agent, "/api/purchased_tickets")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected the cookie to be kept, got %d", resp.StatusCode)
+		}
+	}
+
+	stats := s.connections.stats()
+	if stats.Sessions != 1 || stats.Opened != 1 || stats.PeakOpen != 1 {
+		t.Errorf("expected a single connection for the session, got %+v", stats)
+	}
+
+	// The connection is kept while an entry holds the session
+	sess.acquire()
+	sess.release()
+	if open := s.connections.open.Load(); open != 1 {
+		t.Errorf("expected the connection to be kept, got %d open", open)
+	}
+	sess.release()
+	if open := s.connections.open.Load(); open != 0 {
+		t.Errorf("expected the connection to be closed, got %d open", open)
+	}
+}
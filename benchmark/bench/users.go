@@ -0,0 +1,114 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"sync"
+
+	"github.com/showwin/ISHOCON3/benchmark/bench/data"
+)
+
+// Every 23rd user in users.csv is reserved for validation, so the load does not touch their tickets.
+const validationUserInterval = 23
+
+// loadUsersCSV parses the embedded users.csv only once.
+var loadUsersCSV = sync.OnceValues(func() ([]User, error) {
+	return parseUsersCSV(data.UsersCSV)
+})
+
+func parseUsersCSV(csvData string) ([]User, error) {
+	reader := csv.NewReader(bytes.NewReader([]byte(csvData)))
+	reader.TrimLeadingSpace = true
+
+	// Read the header line
+	headers, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV headers: %w", err)
+	}
+
+	// Map headers to their indices for flexibility
+	headerMap := make(map[string]int)
+	for idx, header := range headers {
+		headerMap[header] = idx
+	}
+
+	// Ensure required headers are present
+	requiredHeaders := []string{"name", "password", "global_payment_token", "credit_amount"}
+	for _, header := range requiredHeaders {
+		if _, exists := headerMap[header]; !exists {
+			return nil, fmt.Errorf("missing required header: %s", header)
+		}
+	}
+
+	var users []User
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("error reading CSV record: %w", err)
+		}
+
+		creditAmount, err := strconv.Atoi(record[headerMap["credit_amount"]])
+		if err != nil {
+			return nil, fmt.Errorf("invalid credit_amount of %s: %w", record[headerMap["name"]], err)
+		}
+		users = append(users, User{
+			Name:               record[headerMap["name"]],
+			Password:           record[headerMap["password"]],
+			GlobalPaymentToken: record[headerMap["global_payment_token"]],
+			CreditAmount:       creditAmount,
+		})
+	}
+
+	return users, nil
+}
+
+// splitUserPools splits users into the validation pool and the load pool.
+// The n-th user (1-based) goes to the validation pool if n is a multiple of validationUserInterval.
+func splitUserPools(users []User) (validation []User, load []User) {
+	for i, user := range users {
+		if (i+1)%validationUserInterval == 0 {
+			validation = append(validation, user)
+		} else {
+			load = append(load, user)
+		}
+	}
+	return validation, load
+}
+
+// userPool hands out random users.
+// If unique is true, a user is handed out at most once, so no two sessions log in as the same user.
+type userPool struct {
+	mu     sync.Mutex
+	users  []User
+	unique bool
+}
+
+func newUserPool(users []User, unique bool) *userPool {
+	// Copy because a unique pool removes the handed out users
+	return &userPool{users: append([]User(nil), users...), unique: unique}
+}
+
+func (p *userPool) pick(rng *rand.Rand) (User, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.users) == 0 {
+		return User{}, errors.New("no user left in the pool")
+	}
+	i := rng.Intn(len(p.users))
+	user := p.users[i]
+	if p.unique {
+		last := len(p.users) - 1
+		p.users[i] = p.users[last]
+		p.users = p.users[:last]
+	}
+	return user, nil
+}
@@ -0,0 +1,48 @@
+package bench
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestLoadUsersCSV(t *testing.T) {
+	users, err := loadUsersCSV()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(users) != 50000 || users[0].Name != "user1" {
+		t.Fatalf("unexpected users: %d users, first %+v", len(users), users[0])
+	}
+
+	validation, load := splitUserPools(users)
+	if len(validation)+len(load) != len(users) {
+		t.Errorf("users are lost: %d + %d != %d", len(validation), len(load), len(users))
+	}
+	if validation[0].Name != "user23" || validation[1].Name != "user46" {
+		t.Errorf("unexpected validation users: %s, %s", validation[0].Name, validation[1].Name)
+	}
+}
+
+func TestUniqueUserPool(t *testing.T) {
+	users := []User{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	pool := newUserPool(users, true)
+	rng := rand.New(rand.NewSource(1))
+
+	seen := make(map[string]bool)
+	for range users {
+		user, err := pool.pick(rng)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if seen[user.Name] {
+			t.Errorf("user %s was handed out twice", user.Name)
+		}
+		seen[user.Name] = true
+	}
+	if _, err := pool.pick(rng); err == nil {
+		t.Error("expected an error when the pool is exhausted")
+	}
+	if len(users) != 3 || users[0].Name != "a" {
+		t.Errorf("the source users were modified: %+v", users)
+	}
+}
@@ -1,3 +1,420 @@
 package bench
 
-// 重複した席のチケットを販売しなかったか確認
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Number of users to log in as during the post-validation
+const postValidationSampleUsers = 20
+
+type ViolationKind string
+
+const (
+	ViolationPreValidation           ViolationKind = "pre_validation"
+	ViolationDoubleBooking           ViolationKind = "double_booking"
+	ViolationMissingTicket           ViolationKind = "missing_ticket"
+	ViolationPhantomTicket           ViolationKind = "phantom_ticket"
+	ViolationRefundedTicketListed    ViolationKind = "refunded_ticket_listed"
+	ViolationTrainTicketCount        ViolationKind = "train_ticket_count_mismatch"
+	ViolationPostValidationRequest   ViolationKind = "post_validation_request_failed"
+	ViolationNoneLabelWithSeatsLeft  ViolationKind = "none_label_with_seats_left"
+	ViolationLotsLabelWithoutSeats   ViolationKind = "lots_label_without_seats"
+	ViolationPriceMismatch           ViolationKind = "price_mismatch"
+	ViolationInvalidQRCode           ViolationKind = "invalid_qr_code"
+	ViolationSecurity                ViolationKind = "security_violation"
+	ViolationDuplicateEntry          ViolationKind = "duplicate_entry"
+	ViolationUnknownSessionStatus    ViolationKind = "unknown_session_status"
+	ViolationSessionExpiredEarly     ViolationKind = "session_expired_early"
+	ViolationSessionNotExpired       ViolationKind = "session_not_expired"
+	ViolationSessionAfterLogout      ViolationKind = "session_alive_after_logout"
+	ViolationLoggedOutUserCounted    ViolationKind = "logged_out_user_counted"
+	ViolationWaitingRoomOverCapacity ViolationKind = "waiting_room_over_capacity"
+	ViolationWaitingRoomStarvation   ViolationKind = "waiting_room_starvation"
+	ViolationClockDrift              ViolationKind = "clock_drift"
+)
+
+// ViolationRule decides how a violation affects the score.
+// A violation either fails the whole benchmark or deducts Penalty points from the score.
+// A violation with neither is only flagged.
+type ViolationRule struct {
+	Fail    bool
+	Penalty int64
+}
+
+var violationRules = map[ViolationKind]ViolationRule{
+	ViolationPreValidation:          {Fail: true},
+	ViolationDoubleBooking:          {Fail: true},
+	ViolationMissingTicket:          {Fail: true},
+	ViolationPhantomTicket:          {Penalty: 10},
+	ViolationRefundedTicketListed:   {Fail: true},
+	ViolationTrainTicketCount:       {Penalty: 50},
+	ViolationPostValidationRequest:  {Fail: true},
+	ViolationNoneLabelWithSeatsLeft: {Penalty: 10},
+	ViolationLotsLabelWithoutSeats:  {},
+	// The sales of a mispriced reservation are not counted instead of deducting points
+	ViolationPriceMismatch: {},
+	// The passenger cannot enter the gate with an invalid QR code, so the sale is lost
+	ViolationInvalidQRCode: {},
+	ViolationSecurity:      {Fail: true},
+	// More than the sales of a seat for the longest trip (7000 yen), so skipping the entries table never pays
+	ViolationDuplicateEntry:       {Penalty: 100},
+	ViolationUnknownSessionStatus: {Penalty: 10},
+	// Expiring sessions early cuts the users off before they finish buying
+	ViolationSessionExpiredEarly: {Penalty: 10},
+	// A session living too long only keeps other users in the waiting room longer
+	ViolationSessionNotExpired: {},
+	// The reference app identifies the user by the name in the cookie and cannot revoke it,
+	// so logout is only checked and flagged
+	ViolationSessionAfterLogout:   {},
+	ViolationLoggedOutUserCounted: {},
+	// Letting everyone in bypasses the waiting room the users are expected to go through
+	ViolationWaitingRoomOverCapacity: {Fail: true},
+	// The reference app admits whoever polls first when a seat frees up, so starvation is only flagged
+	ViolationWaitingRoomStarvation: {},
+	// The benchmark may start its clock late when /api/initialize is slow, so drift is only flagged
+	ViolationClockDrift: {},
+}
+
+// ViolationKinds returns all kinds of violations the benchmark checks.
+func ViolationKinds() []ViolationKind {
+	return []ViolationKind{
+		ViolationPreValidation,
+		ViolationDoubleBooking,
+		ViolationMissingTicket,
+		ViolationPhantomTicket,
+		ViolationRefundedTicketListed,
+		ViolationTrainTicketCount,
+		ViolationPostValidationRequest,
+		ViolationNoneLabelWithSeatsLeft,
+		ViolationLotsLabelWithoutSeats,
+		ViolationPriceMismatch,
+		ViolationInvalidQRCode,
+		ViolationSecurity,
+		ViolationDuplicateEntry,
+		ViolationUnknownSessionStatus,
+		ViolationSessionExpiredEarly,
+		ViolationSessionNotExpired,
+		ViolationSessionAfterLogout,
+		ViolationLoggedOutUserCounted,
+		ViolationWaitingRoomOverCapacity,
+		ViolationWaitingRoomStarvation,
+		ViolationClockDrift,
+	}
+}
+
+type Violation struct {
+	Kind    ViolationKind
+	Message string
+	// Details holds machine-readable information about the violation (e.g., schedule_id, seat)
+	Details map[string]string
+}
+
+func (v Violation) Rule() ViolationRule {
+	return violationRules[v.Kind]
+}
+
+// violationRecorder collects the violations detected during the load.
+type violationRecorder struct {
+	mu         sync.Mutex
+	violations []Violation
+}
+
+func (r *violationRecorder) add(violations ...Violation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.violations = append(r.violations, violations...)
+}
+
+func (r *violationRecorder) all() []Violation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Violation(nil), r.violations...)
+}
+
+// summarizeViolations returns the first failing violation (nil if none) and the total penalty of the others.
+func summarizeViolations(violations []Violation) (*Violation, int64) {
+	var failed *Violation
+	var penalty int64
+	for i, v := range violations {
+		rule := v.Rule()
+		if rule.Fail {
+			if failed == nil {
+				failed = &violations[i]
+			}
+			continue
+		}
+		penalty += rule.Penalty
+	}
+	return failed, penalty
+}
+
+type ticketState int32
+
+const (
+	ticketReserved   ticketState = iota
+	ticketPurchasing             // purchase request was sent, but the result is unknown
+	ticketPurchased
+	ticketPurchaseFailed
+	ticketRefunding // refund request was sent, but the result is unknown
+	ticketRefunded
+)
+
+// ticketRecord is what the benchmark knows about a reservation made by one of its users.
+type ticketRecord struct {
+	user        User
+	reservation Reservation
+	state       atomic.Int32
+	// entryToken is stored once the ticket is used to enter the gate
+	entryToken atomic.Value // string
+}
+
+func (r *ticketRecord) State() ticketState {
+	return ticketState(r.state.Load())
+}
+
+// enteredToken returns the entry token used to enter the gate. ok is false if the ticket has not entered.
+func (r *ticketRecord) enteredToken() (token string, ok bool) {
+	token, ok = r.entryToken.Load().(string)
+	return token, ok
+}
+
+// isUncertain reports whether the server may or may not have processed the last request for the ticket.
+func (r *ticketRecord) isUncertain() bool {
+	state := r.State()
+	return state == ticketPurchasing || state == ticketRefunding
+}
+
+// recordReservation starts tracking a reservation returned by /api/reserve.
+func (s *Scenario) recordReservation(user User, reservation Reservation) {
+	record := &ticketRecord{user: user, reservation: reservation}
+	record.state.Store(int32(ticketReserved))
+	s.ticketLedger.Store(reservation.ReservationID, record)
+}
+
+// updateTicketState updates the state of a tracked reservation. Unknown reservations are ignored.
+func (s *Scenario) updateTicketState(reservationID string, state ticketState) {
+	value, ok := s.ticketLedger.Load(reservationID)
+	if !ok {
+		return
+	}
+	value.(*ticketRecord).state.Store(int32(state))
+}
+
+// recordEntry marks a tracked reservation as entered with entryToken. Unknown reservations are ignored.
+func (s *Scenario) recordEntry(reservationID string, entryToken string) {
+	value, ok := s.ticketLedger.Load(reservationID)
+	if !ok {
+		return
+	}
+	value.(*ticketRecord).entryToken.Store(entryToken)
+}
+
+// runPostValidation checks the application state is consistent with what the benchmark did
+// after the load has finished.
+func (s *Scenario) runPostValidation(ctx context.Context) []Violation {
+	violations := s.validateNoDoubleBooking()
+	violations = append(violations, s.availability.allViolations()...)
+	violations = append(violations, s.violations.all()...)
+
+	// Group the tracked tickets by user
+	ticketsByUser := make(map[string][]*ticketRecord)
+	users := []User{}
+	s.ticketLedger.Range(func(_, value interface{}) bool {
+		record := value.(*ticketRecord)
+		if _, ok := ticketsByUser[record.user.Name]; !ok {
+			users = append(users, record.user)
+		}
+		ticketsByUser[record.user.Name] = append(ticketsByUser[record.user.Name], record)
+		return true
+	})
+
+	rng := s.newRand(streamPostValidation)
+	rng.Shuffle(len(users), func(i, j int) {
+		users[i], users[j] = users[j], users[i]
+	})
+	if len(users) > postValidationSampleUsers {
+		users = users[:postValidationSampleUsers]
+	}
+
+	for _, user := range users {
+		violations = append(violations, s.validatePurchasedTickets(ctx, user, ticketsByUser[user.Name])...)
+	}
+
+	violations = append(violations, s.validateTrainSales(ctx)...)
+
+	s.log.Info("PostValidation ended", "sampled_users", len(users), "violations", len(violations))
+	return violations
+}
+
+// validatePurchasedTickets compares /api/purchased_tickets of the user with the tickets the benchmark recorded.
+func (s *Scenario) validatePurchasedTickets(ctx context.Context, user User, records []*ticketRecord) []Violation {
+	agent, err := s.newAgent()
+	if err != nil {
+		s.log.Error("Failed to create agent", "error", err.Error())
+		return nil
+	}
+	defer agent.HttpClient.CloseIdleConnections()
+
+	if err := s.postLogin(ctx, agent, user); err != nil {
+		return []Violation{{
+			Kind:    ViolationPostValidationRequest,
+			Message: fmt.Sprintf("failed to login as %s: %s", user.Name, err.Error()),
+		}}
+	}
+	purchasedTickets, err := s.getPurchasedTickets(ctx, agent, user)
+	if err != nil {
+		return []Violation{{
+			Kind:    ViolationPostValidationRequest,
+			Message: fmt.Sprintf("failed to get purchased tickets of %s: %s", user.Name, err.Error()),
+		}}
+	}
+
+	listed := make(map[string]bool, len(purchasedTickets.Tickets))
+	for _, ticket := range purchasedTickets.Tickets {
+		listed[ticket.ReservationID] = true
+	}
+	recorded := make(map[string]*ticketRecord, len(records))
+	for _, record := range records {
+		recorded[record.reservation.ReservationID] = record
+	}
+
+	var violations []Violation
+	for _, record := range records {
+		id := record.reservation.ReservationID
+		switch record.State() {
+		case ticketPurchased:
+			if !listed[id] {
+				violations = append(violations, Violation{
+					Kind:    ViolationMissingTicket,
+					Message: fmt.Sprintf("purchased reservation %s of %s is not listed", id, user.Name),
+				})
+			}
+		case ticketRefunded:
+			if listed[id] {
+				violations = append(violations, Violation{
+					Kind:    ViolationRefundedTicketListed,
+					Message: fmt.Sprintf("refunded reservation %s of %s is still listed", id, user.Name),
+				})
+			}
+		}
+	}
+
+	for _, ticket := range purchasedTickets.Tickets {
+		record, ok := recorded[ticket.ReservationID]
+		if ok && (record.State() == ticketPurchased || record.State() == ticketRefunded || record.isUncertain()) {
+			if v, ok := s.verifyPurchasedTicketPrice(ticket); ok {
+				violations = append(violations, v)
+			}
+			continue
+		}
+		violations = append(violations, Violation{
+			Kind:    ViolationPhantomTicket,
+			Message: fmt.Sprintf("reservation %s of %s is listed but was never purchased", ticket.ReservationID, user.Name),
+		})
+	}
+
+	return violations
+}
+
+// validateTrainSales compares tickets_sold of /api/admin/train_sales with the tickets the benchmark purchased per train.
+func (s *Scenario) validateTrainSales(ctx context.Context) []Violation {
+	agent, err := s.newAgent()
+	if err != nil {
+		s.log.Error("Failed to create agent", "error", err.Error())
+		return nil
+	}
+	defer agent.HttpClient.CloseIdleConnections()
+
+	if err := s.adminLogin(ctx, agent); err != nil {
+		return []Violation{{
+			Kind:    ViolationPostValidationRequest,
+			Message: fmt.Sprintf("failed to login as admin: %s", err.Error()),
+		}}
+	}
+	trainSales, err := s.getAdminTrainSales(ctx, agent)
+	if err != nil {
+		return []Violation{{
+			Kind:    ViolationPostValidationRequest,
+			Message: fmt.Sprintf("failed to get train sales: %s", err.Error()),
+		}}
+	}
+
+	// Tickets whose last request result is unknown may or may not be counted by the application
+	expectedTickets := make(map[string]int64)
+	uncertainTickets := make(map[string]int64)
+	s.ticketLedger.Range(func(_, value interface{}) bool {
+		record := value.(*ticketRecord)
+		trainName := trainNameFromScheduleID(record.reservation.ScheduleID)
+		seats := int64(len(record.reservation.Seats))
+		switch {
+		case record.State() == ticketPurchased:
+			expectedTickets[trainName] += seats
+		case record.isUncertain():
+			uncertainTickets[trainName] += seats
+		}
+		return true
+	})
+
+	actualTickets := make(map[string]int64, len(trainSales.Trains))
+	for _, train := range trainSales.Trains {
+		actualTickets[train.TrainName] += train.TicketsSold
+	}
+	trainNames := make(map[string]bool)
+	for name := range expectedTickets {
+		trainNames[name] = true
+	}
+	for name := range actualTickets {
+		trainNames[name] = true
+	}
+
+	var violations []Violation
+	for name := range trainNames {
+		expected := expectedTickets[name]
+		actual := actualTickets[name]
+		if actual < expected || actual > expected+uncertainTickets[name] {
+			violations = append(violations, Violation{
+				Kind:    ViolationTrainTicketCount,
+				Message: fmt.Sprintf("tickets_sold of train %s is %d, but expected %d (+%d uncertain)", name, actual, expected, uncertainTickets[name]),
+			})
+		}
+	}
+
+	return violations
+}
+
+// validateNoDoubleBooking lists every seat sold twice for the same section during the run.
+func (s *Scenario) validateNoDoubleBooking() []Violation {
+	var violations []Violation
+	for _, conflict := range s.seatIndex.allConflicts() {
+		violations = append(violations, Violation{
+			Kind:    ViolationDoubleBooking,
+			Message: fmt.Sprintf("Double booking detected: Schedule %s, Seat %s, Section %s (reservations %s and %s)", conflict.ScheduleID, conflict.Seat, conflict.Section, conflict.First.ReservationID, conflict.Second.ReservationID),
+			Details: map[string]string{
+				"schedule_id":           conflict.ScheduleID,
+				"seat":                  conflict.Seat,
+				"section":               conflict.Section,
+				"first_reservation_id":  conflict.First.ReservationID,
+				"first_user":            conflict.First.User,
+				"first_purchased_at":    conflict.First.PurchasedAt.Format(time.RFC3339Nano),
+				"second_reservation_id": conflict.Second.ReservationID,
+				"second_user":           conflict.Second.User,
+				"second_purchased_at":   conflict.Second.PurchasedAt.Format(time.RFC3339Nano),
+			},
+		})
+	}
+	return violations
+}
+
+// trainNameFromScheduleID converts schedule ID to train name (e.g., "E5001-2" -> "E5001")
+func trainNameFromScheduleID(scheduleID string) string {
+	idx := strings.LastIndex(scheduleID, "-")
+	if idx == -1 {
+		return scheduleID
+	}
+	return scheduleID[:idx]
+}
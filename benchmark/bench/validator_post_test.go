@@ -0,0 +1,40 @@
+package bench
+
+import (
+	"testing"
+)
+
+func TestSummarizeViolations(t *testing.T) {
+	violations := []Violation{
+		{Kind: ViolationPhantomTicket, Message: "phantom"},
+		{Kind: ViolationTrainTicketCount, Message: "count"},
+		{Kind: ViolationMissingTicket, Message: "missing"},
+		{Kind: ViolationDoubleBooking, Message: "double"},
+	}
+
+	failed, penalty := summarizeViolations(violations)
+	if failed == nil || failed.Kind != ViolationMissingTicket {
+		t.Errorf("Expected the first failing violation to be %s, got %v", ViolationMissingTicket, failed)
+	}
+	if penalty != 60 {
+		t.Errorf("Expected penalty 60, got %d", penalty)
+	}
+
+	failed, penalty = summarizeViolations(nil)
+	if failed != nil || penalty != 0 {
+		t.Errorf("Expected no failure and no penalty, got %v and %d", failed, penalty)
+	}
+}
+
+func TestTrainNameFromScheduleID(t *testing.T) {
+	cases := map[string]string{
+		"E5001-1":  "E5001",
+		"B4105-12": "B4105",
+		"L2001":    "L2001",
+	}
+	for scheduleID, expected := range cases {
+		if got := trainNameFromScheduleID(scheduleID); got != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, scheduleID, got)
+		}
+	}
+}
@@ -2,55 +2,262 @@ package bench
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
 	"time"
 
 	"github.com/isucon/isucandar/agent"
-	"github.com/isucon/isucandar/worker"
 )
 
+// errPreValidation is wrapped by every error returned from the pre-validation phase.
+// The benchmark score is zeroed when the run is interrupted by this error.
+var errPreValidation = errors.New("pre-validation failed")
+
+// validationTicket is a ticket bought by the pre-validation user.
+type validationTicket struct {
+	Reservation
+	EntryToken string
+	QRCodeURL  string
+}
+
+// runPreValidation checks the basic behavior of the application before the load starts.
+// Checks that need to wait for the train departure continue in the background,
+// and their failure is reported through criticalErrors.
 func (s *Scenario) runPreValidation(ctx context.Context) error {
-	agent, err := agent.NewAgent(agent.WithBaseURL(s.targetURL), agent.WithTimeout(10*time.Second), agent.WithDefaultTransport())
+	user, err := s.getRandomUser(s.newRand(streamPreValidation), true)
 	if err != nil {
-		s.log.Error("failed to create agent", err.Error())
+		return fmt.Errorf("%w: failed to get random user for validation: %w", errPreValidation, err)
 	}
-
-	user, err := s.getRandomUser(true)
+	sess, err := s.newUserSession(user)
 	if err != nil {
-		s.log.Error("failed to get random user for validation", err.Error())
+		return fmt.Errorf("%w: failed to create agent: %w", errPreValidation, err)
 	}
+	defer sess.release()
+	agent := sess.agent
 	s.log.Info("START PreValidation", "user", user.Name)
 
-	s.postLogin(ctx, agent, user)
-	s.waitInWaitingRoom(ctx, agent, user)
+	if err := s.postLogin(ctx, agent, user); err != nil {
+		return fmt.Errorf("%w: failed to login: %w", errPreValidation, err)
+	}
+	if err := s.waitInWaitingRoom(ctx, agent, user); err != nil {
+		return fmt.Errorf("%w: failed to pass the waiting room: %w", errPreValidation, err)
+	}
 
-	// Start worker to buy tickets
-	childCtx := context.Background()
-	ticketScenarioWorker, err := worker.NewWorker(func(childCtx context.Context, _ int) {
-		s.runBuyTicketValidation(childCtx, agent, user)
-	}, worker.WithLoopCount(1), worker.WithMaxParallelism(1))
-	if err != nil {
-		s.log.Error("failed to create runBuyTicketValidation worker", err.Error(), "user", user.Name)
+	if err := s.runBuyTicketValidation(ctx, sess); err != nil {
+		return fmt.Errorf("%w: %w", errPreValidation, err)
 	}
-	go func() {
-		ticketScenarioWorker.Process(childCtx)
-	}()
 
-	currentTime := getApplicationClock(s.initializedAt)
-	s.log.Info("PreValidation ended", "current time", currentTime, "user", user.Name)
+	currentTime := s.clock.Now()
+	s.log.Info("PreValidation ended", "current_time", currentTime, "user", user.Name)
 	return nil
 }
 
-func (s *Scenario) runBuyTicketValidation(ctx context.Context, agent *agent.Agent, user User) error {
-	// Buy the earliest ticket two times alone, 1) enter before departure, 2) enter after departure and refund.
-	// and buy another ticket to check the credit limit exceeding.
-	// Since MIN_CREDIT = 5000, we can at least by 1000, 3000 (for three person) yen tickets.
+func (s *Scenario) runBuyTicketValidation(ctx context.Context, sess *userSession) error {
+	agent, user := sess.agent, sess.user
+	resp, err := HttpGet(ctx, agent, "/api/schedules")
+	if err != nil {
+		return fmt.Errorf("failed to get /api/schedules: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("/api/schedules returned %d status: %s", resp.StatusCode, string(resp.Body))
+	}
+	var schedules TrainScheduleResp
+	if err := json.Unmarshal(resp.Body, &schedules); err != nil {
+		return fmt.Errorf("failed to unmarshal /api/schedules response: %w", err)
+	}
 
-	// Check the displayed price is correct
+	currentTime := s.clock.Now()
+	// Travel the first section of the line
+	stations := s.line.StationIDs()
+	from, to := stations[0], stations[1]
+	schedule, departureAt, err := findEarliestSchedule(s.line, from, to, currentTime, schedules.Schedules)
+	if err != nil {
+		return fmt.Errorf("no schedule available for validation: %w", err)
+	}
+
+	// Buy the earliest ticket two times alone, 1) enter before departure, 2) enter after departure and refund.
+	var tickets [2]validationTicket
+	for i := range tickets {
+		ticket, err := s.buyValidationTicket(ctx, agent, user, schedule.ID, from, to, departureAt)
+		if err != nil {
+			return err
+		}
+		tickets[i] = *ticket
+	}
+	entered, refunded := tickets[0], tickets[1]
 
 	// Check entry token in QR code image
+	for _, ticket := range tickets {
+		qrResp, err := s.getQRCode(ctx, agent, ticket.QRCodeURL, user)
+		if err != nil {
+			return fmt.Errorf("failed to get QR code %s: %w", ticket.QRCodeURL, err)
+		}
+		if err := verifyQRCodeImage(qrResp, ticket.EntryToken); err != nil {
+			return fmt.Errorf("QR code %s is invalid: %w", ticket.QRCodeURL, err)
+		}
+	}
+
+	// 1) Enter before departure using the token read from the QR code
+	entryResp, err := s.enterGate(ctx, agent, EntryReq{EntryToken: entered.EntryToken}, user)
+	if err != nil {
+		return fmt.Errorf("failed to enter before departure: %w", err)
+	}
+	if entryResp.Status != "success" {
+		return fmt.Errorf("entry before departure was rejected: reservation %s, status %s", entered.ReservationID, entryResp.Status)
+	}
+	s.totalSales[rand.Intn(32)].Add(int64(entered.TotalPrice))
+	s.recordEntry(entered.ReservationID, entered.EntryToken)
 
 	// Try to enter twice using the same entry token
+	entryResp, err = s.enterGate(ctx, agent, EntryReq{EntryToken: entered.EntryToken}, user)
+	if err != nil {
+		return fmt.Errorf("failed to enter twice: %w", err)
+	}
+	if entryResp.Status != "already_entered" {
+		return fmt.Errorf("second entry with the same token was not rejected: reservation %s, status %s", entered.ReservationID, entryResp.Status)
+	}
+
+	// 2) Enter after departure and refund. This has to wait for the departure, so continue without blocking the load.
+	sess.acquire()
+	s.goRefund(func() {
+		defer sess.release()
+		if err := s.runRefundValidation(ctx, sess, entered, refunded); err != nil {
+			s.log.Error("PreValidation failed", "error", err.Error(), "user", user.Name)
+			s.criticalErrors.report(ErrorCategoryPreValidation, fmt.Errorf("%w: %w", errPreValidation, err))
+		}
+	})
+
+	return nil
+}
+
+// buyValidationTicket reserves and purchases a ticket for a section for one person,
+// and checks the reservation matches the request.
+func (s *Scenario) buyValidationTicket(ctx context.Context, agent *agent.Agent, user User, scheduleID string, from string, to string, departureAt string) (*validationTicket, error) {
+	reservationResp, err := s.makeReservation(ctx, agent, user, ReservationReq{
+		ScheduleID:    scheduleID,
+		FromStationID: from,
+		ToStationID:   to,
+		NumPeople:     1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve schedule %s: %w", scheduleID, err)
+	}
+	if reservationResp.Status != "success" || reservationResp.Reserved == nil {
+		return nil, fmt.Errorf("failed to reserve schedule %s: status %s, error_code %s", scheduleID, reservationResp.Status, reservationResp.ErrorCode)
+	}
+	reservation := *reservationResp.Reserved
+
+	if reservation.ScheduleID != scheduleID {
+		return nil, fmt.Errorf("reserved schedule is wrong: expected %s, got %s", scheduleID, reservation.ScheduleID)
+	}
+	fromName, toName := s.line.stationName(from), s.line.stationName(to)
+	if reservation.FromStation != fromName || reservation.ToStation != toName {
+		return nil, fmt.Errorf("reserved stations are wrong: expected %s -> %s, got %s -> %s", fromName, toName, reservation.FromStation, reservation.ToStation)
+	}
+	if reservation.DepartureAt != departureAt {
+		return nil, fmt.Errorf("departure time is wrong: expected %s, got %s", departureAt, reservation.DepartureAt)
+	}
+	if len(reservation.Seats) != 1 {
+		return nil, fmt.Errorf("number of reserved seats is wrong: expected 1, got %d", len(reservation.Seats))
+	}
+
+	// Check the displayed price is correct. A single seat for one section costs 1000 yen without discount.
+	if reservation.TotalPrice != 1000 || reservation.IsDiscounted {
+		return nil, fmt.Errorf("displayed price is wrong: expected 1000 without discount, got %d (is_discounted: %t)", reservation.TotalPrice, reservation.IsDiscounted)
+	}
+
+	purchaseResp, err := s.purchaseReservation(ctx, agent, user, PurchaseReq{ReservationID: reservation.ReservationID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to purchase reservation %s: %w", reservation.ReservationID, err)
+	}
+	if purchaseResp.Status != "success" {
+		return nil, fmt.Errorf("failed to purchase reservation %s: status %s, message %s", reservation.ReservationID, purchaseResp.Status, purchaseResp.Message)
+	}
+	if purchaseResp.EntryToken == "" || purchaseResp.QRCodeURL == "" {
+		return nil, fmt.Errorf("entry token or QR code URL is missing for reservation %s", reservation.ReservationID)
+	}
+	s.recordPurchase(user, reservation)
+
+	return &validationTicket{
+		Reservation: reservation,
+		EntryToken:  purchaseResp.EntryToken,
+		QRCodeURL:   purchaseResp.QRCodeURL,
+	}, nil
+}
+
+// runRefundValidation waits for the departure of the refunded ticket, checks the entry is rejected,
+// refunds it, and checks the purchased ticket list.
+func (s *Scenario) runRefundValidation(ctx context.Context, sess *userSession, entered validationTicket, refunded validationTicket) error {
+	agent, user := sess.agent, sess.user
+	departedAt, err := s.clock.RealTime(refunded.DepartureAt)
+	if err != nil {
+		return err
+	}
+
+	// Wait until the train has surely departed, 10 minutes in app time after the departure
+	select {
+	case <-time.After(time.Until(departedAt) + s.clock.RealDuration(10*time.Minute)):
+	case <-ctx.Done():
+		// The profile is too short to wait for the departure
+		s.log.Warn("Benchmark finished before the train departed. Skipped the validation of the refund and the purchased tickets.",
+			"reservation_id", refunded.ReservationID, "departure_at", refunded.DepartureAt, "user", user.Name)
+		return nil
+	}
+
+	// Use a separate context so the refund can complete even after main benchmark ends
+	refundCtx, refundCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer refundCancel()
+
+	entryResp, err := s.enterGate(refundCtx, agent, EntryReq{EntryToken: refunded.EntryToken}, user)
+	if err != nil {
+		return fmt.Errorf("failed to enter after departure: %w", err)
+	}
+	if entryResp.Status != "train_departed" {
+		return fmt.Errorf("entry after departure was not rejected: reservation %s, status %s", refunded.ReservationID, entryResp.Status)
+	}
+
+	// The session has likely expired while waiting for the departure
+	if err := s.postLogin(refundCtx, agent, user); err != nil {
+		return fmt.Errorf("failed to login: %w", err)
+	}
+	if err := s.waitInWaitingRoom(refundCtx, agent, user); err != nil {
+		return fmt.Errorf("failed to pass the waiting room: %w", err)
+	}
+
+	s.beginRefund(refunded.Reservation)
+	refundResp, err := s.requestRefund(refundCtx, agent, user, refunded.ReservationID)
+	if err != nil {
+		return fmt.Errorf("failed to refund reservation %s: %w", refunded.ReservationID, err)
+	}
+	if refundResp.Status != "success" {
+		s.recordRefundFailure(refunded.Reservation)
+		return fmt.Errorf("failed to refund reservation %s: error_code %s", refunded.ReservationID, refundResp.ErrorCode)
+	}
+	s.recordRefund(refunded.Reservation)
 
 	// Check bought ticket list. Refunded tickets should not be in the list.
+	purchasedTickets, err := s.getPurchasedTickets(refundCtx, agent, user)
+	if err != nil {
+		return fmt.Errorf("failed to get purchased tickets: %w", err)
+	}
+	enteredFound := false
+	for _, ticket := range purchasedTickets.Tickets {
+		switch ticket.ReservationID {
+		case refunded.ReservationID:
+			return fmt.Errorf("refunded reservation %s is still in the purchased ticket list", refunded.ReservationID)
+		case entered.ReservationID:
+			enteredFound = true
+			if !ticket.IsEntered {
+				return fmt.Errorf("entered reservation %s is not marked as entered", entered.ReservationID)
+			}
+		}
+	}
+	if !enteredFound {
+		return fmt.Errorf("purchased reservation %s is missing from the purchased ticket list", entered.ReservationID)
+	}
+
+	s.log.Info("PreValidation refund check passed", "user", user.Name)
 	return nil
 }
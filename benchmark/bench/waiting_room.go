@@ -0,0 +1,131 @@
+package bench
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The application may admit a few users too many when they poll at the same moment,
+// so only admissions beyond this factor of the maximum are reported.
+const overAdmissionFactor = 2
+
+// WaitingRoomStats summarizes how the users of the benchmark were admitted from the waiting room.
+type WaitingRoomStats struct {
+	Admissions int64
+	// MaxActiveUsers is the expected maximum of active users, and PeakActiveUsers is the most users
+	// the application surely counted as active when it admitted a user
+	MaxActiveUsers  int
+	PeakActiveUsers int
+	// WaitTime is the time from the first /api/waiting_status to "ready"
+	WaitTime     LatencyStats
+	StarvedUsers int64
+}
+
+// waitingUser is a user polling /api/waiting_status.
+type waitingUser struct {
+	seq       int64 // order of arrival
+	arrivedAt time.Time
+	overtaken int // users who arrived later and were admitted first
+	starved   bool
+}
+
+// waitingRoomMonitor follows the users of the benchmark through the waiting room.
+type waitingRoomMonitor struct {
+	profile    WaitingRoomProfile
+	mu         sync.Mutex
+	nextSeq    int64
+	waiting    map[int64]*waitingUser
+	admissions int64
+	peak       int
+	waitTimes  latencyHistogram
+	starved    int64
+}
+
+func newWaitingRoomMonitor(profile WaitingRoomProfile) *waitingRoomMonitor {
+	return &waitingRoomMonitor{
+		profile: profile,
+		waiting: make(map[int64]*waitingUser),
+	}
+}
+
+// arrive registers a user starting to poll /api/waiting_status.
+func (m *waitingRoomMonitor) arrive(at time.Time) *waitingUser {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextSeq++
+	w := &waitingUser{seq: m.nextSeq, arrivedAt: at}
+	m.waiting[w.seq] = w
+	return w
+}
+
+// leave unregisters a user who stopped waiting without being admitted.
+func (m *waitingRoomMonitor) leave(w *waitingUser) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.waiting, w.seq)
+}
+
+// admit records the admission of w. active is the number of users the application surely counts as active,
+// including w. It returns a violation if the application admitted far more users than the maximum.
+func (m *waitingRoomMonitor) admit(w *waitingUser, at time.Time, active int) (Violation, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.waiting, w.seq)
+	for _, other := range m.waiting {
+		if other.seq < w.seq {
+			other.overtaken++
+		}
+	}
+	m.admissions++
+	m.peak = max(m.peak, active)
+	m.waitTimes.observe(at.Sub(w.arrivedAt))
+
+	if m.profile.MaxActiveUsers == 0 || active <= m.profile.MaxActiveUsers*overAdmissionFactor {
+		return Violation{}, false
+	}
+	return Violation{
+		Kind:    ViolationWaitingRoomOverCapacity,
+		Message: fmt.Sprintf("waiting room admitted a user while %d users were active, but the maximum is %d", active, m.profile.MaxActiveUsers),
+		Details: map[string]string{
+			"active_users":     strconv.Itoa(active),
+			"max_active_users": strconv.Itoa(m.profile.MaxActiveUsers),
+		},
+	}, true
+}
+
+// checkStarvation returns a violation the first time w has waited longer than the starvation timeout
+// while users who arrived later were admitted.
+func (m *waitingRoomMonitor) checkStarvation(w *waitingUser, at time.Time) (Violation, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	waited := at.Sub(w.arrivedAt)
+	if m.profile.StarvationTimeout == 0 || w.starved || w.overtaken == 0 || waited < m.profile.StarvationTimeout {
+		return Violation{}, false
+	}
+	w.starved = true
+	m.starved++
+	return Violation{
+		Kind:    ViolationWaitingRoomStarvation,
+		Message: fmt.Sprintf("a user has waited for %s while %d users who arrived later were admitted", waited.Round(time.Millisecond), w.overtaken),
+		Details: map[string]string{
+			"waited_ms": strconv.FormatInt(waited.Milliseconds(), 10),
+			"overtaken": strconv.Itoa(w.overtaken),
+		},
+	}, true
+}
+
+func (m *waitingRoomMonitor) stats() WaitingRoomStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return WaitingRoomStats{
+		Admissions:      m.admissions,
+		MaxActiveUsers:  m.profile.MaxActiveUsers,
+		PeakActiveUsers: m.peak,
+		WaitTime:        m.waitTimes.stats(),
+		StarvedUsers:    m.starved,
+	}
+}
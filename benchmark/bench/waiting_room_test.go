@@ -0,0 +1,104 @@
+package bench
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitingRoomStarvation(t *testing.T) {
+	m := newWaitingRoomMonitor(WaitingRoomProfile{MaxActiveUsers: 5, StarvationTimeout: 30 * time.Second})
+	start := time.Now()
+	first := m.arrive(start)
+	second := m.arrive(start.Add(time.Second))
+
+	// Waiting long is not starvation while nobody overtakes
+	if _, ok := m.checkStarvation(first, start.Add(time.Minute)); ok {
+		t.Error("expected no starvation without later admissions")
+	}
+
+	if _, ok := m.admit(second, start.Add(2*time.Second), 3); ok {
+		t.Error("expected no violation within the maximum")
+	}
+	if _, ok := m.checkStarvation(first, start.Add(10*time.Second)); ok {
+		t.Error("expected no starvation before the timeout")
+	}
+	v, ok := m.checkStarvation(first, start.Add(31*time.Second))
+	if !ok || v.Kind != ViolationWaitingRoomStarvation || v.Details["overtaken"] != "1" {
+		t.Errorf("expected starvation, got %+v", v)
+	}
+	// A starved user is counted once
+	if _, ok := m.checkStarvation(first, start.Add(time.Minute)); ok {
+		t.Error("expected the starvation to be reported once")
+	}
+
+	if _, ok := m.admit(first, start.Add(time.Minute), 5); ok {
+		t.Error("expected no violation within the maximum")
+	}
+	stats := m.stats()
+	if stats.Admissions != 2 || stats.PeakActiveUsers != 5 || stats.StarvedUsers != 1 || stats.MaxActiveUsers != 5 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if stats.WaitTime.Max != time.Minute {
+		t.Errorf("expected the longest wait to be 1m, got %s", stats.WaitTime.Max)
+	}
+}
+
+func TestWaitingRoomOverCapacity(t *testing.T) {
+	m := newWaitingRoomMonitor(WaitingRoomProfile{MaxActiveUsers: 5})
+	now := time.Now()
+	// Racing admissions may exceed the maximum a little
+	if _, ok := m.admit(m.arrive(now), now, 7); ok {
+		t.Error("expected no violation within the tolerance")
+	}
+	v, ok := m.admit(m.arrive(now), now, 11)
+	if !ok || v.Kind != ViolationWaitingRoomOverCapacity || !v.Rule().Fail {
+		t.Errorf("expected a failing over capacity violation, got %+v", v)
+	}
+
+	unchecked := newWaitingRoomMonitor(WaitingRoomProfile{})
+	if _, ok := unchecked.admit(unchecked.arrive(now), now, 100); ok {
+		t.Error("expected no violation when the maximum is not configured")
+	}
+}
+
+func TestSurelyActiveUsers(t *testing.T) {
+	tracker := newSessionTracker(5)
+	for _, name := range []string{"u1", "u2", "u3"} {
+		tracker.begin(name).end(true)
+	}
+	// A request without proof of the update does not count
+	tracker.begin("u4").end(false)
+	tracker.logout("u3", time.Now())
+
+	if got := tracker.surelyActiveUsers(time.Now()); got != 2 {
+		t.Errorf("expected 2 active users, got %d", got)
+	}
+	if got := tracker.surelyActiveUsers(time.Now().Add(sessionIdleTimeout)); got != 0 {
+		t.Errorf("expected no active users after the idle timeout, got %d", got)
+	}
+}
+
+func TestSurelyActiveUsersOverlappingLogin(t *testing.T) {
+	tracker := newSessionTracker(5)
+	tracker.begin("u1").end(true)
+
+	// u2 logs in while the waiting status of another user is requested
+	login := tracker.begin("u2")
+	time.Sleep(time.Millisecond)
+	sent := time.Now()
+	time.Sleep(time.Millisecond)
+	login.end(true)
+	if got := tracker.surelyActiveUsers(sent); got != 1 {
+		t.Errorf("expected the overlapping login not to count, got %d active users", got)
+	}
+
+	// A request in flight at the send time does not hide the activity proven before it
+	request := tracker.begin("u1")
+	time.Sleep(time.Millisecond)
+	sent = time.Now()
+	time.Sleep(time.Millisecond)
+	request.end(true)
+	if got := tracker.surelyActiveUsers(sent); got != 2 {
+		t.Errorf("expected 2 active users, got %d", got)
+	}
+}
@@ -0,0 +1,468 @@
+package cmd
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/showwin/ISHOCON3/benchmark/bench"
+)
+
+// printResult writes the human-readable result of the benchmark.
+func printResult(w io.Writer, result *bench.Result) {
+	fmt.Fprintln(w, "\nBenchmark Finished!")
+	if result.CriticalError != "" {
+		fmt.Fprintln(w, "  Interrupted due to critical error:")
+		fmt.Fprintf(w, "  %s\n", result.CriticalError)
+		if len(result.CriticalErrors) > 1 {
+			fmt.Fprintln(w, "  Critical errors:")
+			for _, e := range result.CriticalErrors {
+				fmt.Fprintf(w, "  - [%s] %s\n", e.Category, e.Message)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	if len(result.Violations) > 0 {
+		fmt.Fprintln(w, "  Validation violations:")
+		for _, v := range result.Violations {
+			rule := v.Rule()
+			switch {
+			case rule.Fail:
+				fmt.Fprintf(w, "  - [fail] %s: %s\n", v.Kind, v.Message)
+			case rule.Penalty > 0:
+				fmt.Fprintf(w, "  - [-%d] %s: %s\n", rule.Penalty, v.Kind, v.Message)
+			default:
+				fmt.Fprintf(w, "  - [flag] %s: %s\n", v.Kind, v.Message)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "  Score: %d\n", result.Score)
+	if len(result.ScoreBreakdown) > 0 {
+		printScoreBreakdown(w, result.Scorer, result.ScoreBreakdown)
+	}
+	fmt.Fprintf(w, "  Total Sales: %d\n", result.TotalSales)
+	fmt.Fprintf(w, "  Total Purchased: %d\n", result.TotalPurchased)
+	fmt.Fprintf(w, "  Total Refunds: %d\n", result.TotalRefunds)
+	fmt.Fprintf(w, "  Net Revenue: %d\n", result.NetRevenue())
+	fmt.Fprintf(w, "  Total Tickets: %d\n", result.TotalTickets)
+	fmt.Fprintf(w, "  Ticket Phase: %d/%d\n", result.TicketPhase, result.TicketPhaseCount)
+	fmt.Fprintf(w, "  Sales Phase: %d/%d\n", result.SalesPhase, result.SalesPhaseCount)
+	fmt.Fprintf(w, "  Current Time: %s\n", result.ApplicationTime)
+	if result.Clock.Checks > 0 {
+		fmt.Fprintf(w, "  Clock Checks: %d (%d drifted, max drift %s)\n", result.Clock.Checks, result.Clock.Drifted, result.Clock.MaxDrift)
+	}
+	fmt.Fprintf(w, "  Seed: %d\n\n", result.Seed)
+
+	if result.WaitingRoom.Admissions > 0 {
+		printWaitingRoom(w, result.WaitingRoom)
+	}
+	if result.LoadMode == bench.LoadModeOpen {
+		printArrivals(w, result.Arrivals)
+	}
+	if result.Connections.Sessions > 0 {
+		printConnections(w, result.Connections)
+	}
+	if len(result.Endpoints) > 0 {
+		printEndpoints(w, result.Endpoints)
+	}
+}
+
+// printScoreBreakdown writes the terms the score is made of.
+func printScoreBreakdown(w io.Writer, scorer string, terms []bench.ScoreTerm) {
+	fmt.Fprintf(w, "  Score Breakdown (%s):\n", scorer)
+	for _, t := range terms {
+		fmt.Fprintf(w, "    %s: %+.2f (%s)\n", t.Name, t.Points, t.Detail)
+	}
+}
+
+// printArrivals writes how the users arrived in the open load mode.
+func printArrivals(w io.Writer, stats bench.ArrivalStats) {
+	fmt.Fprintln(w, "  Arrivals:")
+	fmt.Fprintf(w, "    Arrived: %d\n", stats.Arrivals)
+	fmt.Fprintf(w, "    Started: %d\n", stats.Started)
+	fmt.Fprintf(w, "    Abandoned: %d\n", stats.Abandoned)
+	fmt.Fprintf(w, "    Peak Outstanding: %d\n", stats.PeakOutstanding)
+	fmt.Fprintf(w, "    Start Delay: P50 %s, P90 %s, P99 %s, Max %s\n\n",
+		formatLatency(stats.StartDelay.P50), formatLatency(stats.StartDelay.P90), formatLatency(stats.StartDelay.P99), formatLatency(stats.StartDelay.Max))
+}
+
+// printConnections writes how many connections the users opened to the application.
+func printConnections(w io.Writer, stats bench.ConnectionStats) {
+	fmt.Fprintln(w, "  Connections:")
+	fmt.Fprintf(w, "    User Sessions: %d\n", stats.Sessions)
+	fmt.Fprintf(w, "    Opened: %d\n", stats.Opened)
+	fmt.Fprintf(w, "    Peak Open: %d\n\n", stats.PeakOpen)
+}
+
+// printWaitingRoom writes how the users were admitted from the waiting room.
+func printWaitingRoom(w io.Writer, stats bench.WaitingRoomStats) {
+	fmt.Fprintln(w, "  Waiting Room:")
+	fmt.Fprintf(w, "    Admissions: %d\n", stats.Admissions)
+	fmt.Fprintf(w, "    Peak Active Users: %d (max %d)\n", stats.PeakActiveUsers, stats.MaxActiveUsers)
+	fmt.Fprintf(w, "    Wait Time: P50 %s, P90 %s, P99 %s, Max %s\n",
+		formatLatency(stats.WaitTime.P50), formatLatency(stats.WaitTime.P90), formatLatency(stats.WaitTime.P99), formatLatency(stats.WaitTime.Max))
+	fmt.Fprintf(w, "    Starved Users: %d\n\n", stats.StarvedUsers)
+}
+
+// printEndpoints writes the latency and the errors of each route as a table.
+func printEndpoints(w io.Writer, endpoints []bench.EndpointStats) {
+	fmt.Fprintln(w, "  Requests:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  METHOD\tROUTE\tCOUNT\tNON-2XX\tTIMEOUT\tCONN ERR\tP50\tP90\tP99\tMAX")
+	for _, e := range endpoints {
+		fmt.Fprintf(tw, "  %s\t%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n",
+			e.Method, e.Route, e.Requests(), e.Non2xx, e.Timeouts, e.ConnectionErrors,
+			formatLatency(e.P50), formatLatency(e.P90), formatLatency(e.P99), formatLatency(e.Max))
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+}
+
+func formatLatency(d time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+}
+
+type jsonViolation struct {
+	Kind    bench.ViolationKind `json:"kind"`
+	Message string              `json:"message"`
+	Fail    bool                `json:"fail"`
+	Penalty int64               `json:"penalty"`
+	Details map[string]string   `json:"details,omitempty"`
+}
+
+type jsonCriticalError struct {
+	Category bench.ErrorCategory `json:"category"`
+	Message  string              `json:"message"`
+	At       time.Time           `json:"at"`
+}
+
+type jsonScoreTerm struct {
+	Name   string  `json:"name"`
+	Points float64 `json:"points"`
+	Detail string  `json:"detail"`
+}
+
+type jsonLatency struct {
+	P50Ms float64 `json:"p50_ms"`
+	P90Ms float64 `json:"p90_ms"`
+	P99Ms float64 `json:"p99_ms"`
+	MaxMs float64 `json:"max_ms"`
+}
+
+type jsonStatus struct {
+	StatusCode int   `json:"status_code"`
+	Count      int64 `json:"count"`
+	jsonLatency
+}
+
+type jsonEndpoint struct {
+	Method           string `json:"method"`
+	Route            string `json:"route"`
+	Requests         int64  `json:"requests"`
+	Non2xx           int64  `json:"non_2xx"`
+	Timeouts         int64  `json:"timeouts"`
+	ConnectionErrors int64  `json:"connection_errors"`
+	jsonLatency
+	ByStatus []jsonStatus `json:"by_status"`
+}
+
+func newJSONLatency(stats bench.LatencyStats) jsonLatency {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return jsonLatency{P50Ms: ms(stats.P50), P90Ms: ms(stats.P90), P99Ms: ms(stats.P99), MaxMs: ms(stats.Max)}
+}
+
+type jsonWaitingRoom struct {
+	Admissions      int64       `json:"admissions"`
+	MaxActiveUsers  int         `json:"max_active_users"`
+	PeakActiveUsers int         `json:"peak_active_users"`
+	WaitTime        jsonLatency `json:"wait_time"`
+	StarvedUsers    int64       `json:"starved_users"`
+}
+
+type jsonConnections struct {
+	Sessions int64 `json:"sessions"`
+	Opened   int64 `json:"opened"`
+	PeakOpen int64 `json:"peak_open"`
+}
+
+type jsonArrivals struct {
+	Arrivals        int64       `json:"arrivals"`
+	Started         int64       `json:"started"`
+	Abandoned       int64       `json:"abandoned"`
+	PeakOutstanding int64       `json:"peak_outstanding"`
+	StartDelay      jsonLatency `json:"start_delay"`
+}
+
+type jsonClock struct {
+	Checks          int64   `json:"checks"`
+	Drifted         int64   `json:"drifted"`
+	MaxDriftMinutes float64 `json:"max_drift_minutes"`
+}
+
+type jsonResult struct {
+	Score            int64               `json:"score"`
+	Scorer           string              `json:"scorer"`
+	ScoreBreakdown   []jsonScoreTerm     `json:"score_breakdown"`
+	TotalSales       int64               `json:"total_sales"`
+	TotalPurchased   int64               `json:"total_purchased"`
+	TotalRefunds     int64               `json:"total_refunds"`
+	NetRevenue       int64               `json:"net_revenue"`
+	TotalTickets     int64               `json:"total_tickets"`
+	TicketPhase      int                 `json:"ticket_phase"`
+	TicketPhaseCount int                 `json:"ticket_phase_count"`
+	SalesPhase       int                 `json:"sales_phase"`
+	SalesPhaseCount  int                 `json:"sales_phase_count"`
+	CurrentTime      string              `json:"current_time"`
+	AppLanguage      string              `json:"app_language"`
+	Seed             int64               `json:"seed"`
+	CriticalError    string              `json:"critical_error"`
+	CriticalErrors   []jsonCriticalError `json:"critical_errors"`
+	Violations       []jsonViolation     `json:"violations"`
+	DoubleBookings   []jsonViolation     `json:"double_bookings"`
+	Endpoints        []jsonEndpoint      `json:"endpoints"`
+	WaitingRoom      jsonWaitingRoom     `json:"waiting_room"`
+	Connections      jsonConnections     `json:"connections"`
+	LoadMode         bench.LoadMode      `json:"load_mode"`
+	Clock            jsonClock           `json:"clock"`
+	Arrivals         *jsonArrivals       `json:"arrivals,omitempty"`
+	StartedAt        time.Time           `json:"started_at"`
+	FinishedAt       time.Time           `json:"finished_at"`
+	DurationSec      float64             `json:"duration_sec"`
+}
+
+// writeJSONResult writes the result of the benchmark as a JSON document.
+func writeJSONResult(w io.Writer, result *bench.Result) error {
+	doc := jsonResult{
+		Score:            result.Score,
+		Scorer:           result.Scorer,
+		ScoreBreakdown:   []jsonScoreTerm{},
+		TotalSales:       result.TotalSales,
+		TotalPurchased:   result.TotalPurchased,
+		TotalRefunds:     result.TotalRefunds,
+		NetRevenue:       result.NetRevenue(),
+		TotalTickets:     result.TotalTickets,
+		TicketPhase:      result.TicketPhase,
+		TicketPhaseCount: result.TicketPhaseCount,
+		SalesPhase:       result.SalesPhase,
+		SalesPhaseCount:  result.SalesPhaseCount,
+		CurrentTime:      result.ApplicationTime,
+		AppLanguage:      result.AppLanguage,
+		Seed:             result.Seed,
+		LoadMode:         result.LoadMode,
+		CriticalError:    result.CriticalError,
+		CriticalErrors:   []jsonCriticalError{},
+		Violations:       []jsonViolation{},
+		DoubleBookings:   []jsonViolation{},
+		Endpoints:        []jsonEndpoint{},
+		StartedAt:        result.StartedAt,
+		FinishedAt:       result.FinishedAt,
+		DurationSec:      result.Duration().Seconds(),
+		WaitingRoom: jsonWaitingRoom{
+			Admissions:      result.WaitingRoom.Admissions,
+			MaxActiveUsers:  result.WaitingRoom.MaxActiveUsers,
+			PeakActiveUsers: result.WaitingRoom.PeakActiveUsers,
+			WaitTime:        newJSONLatency(result.WaitingRoom.WaitTime),
+			StarvedUsers:    result.WaitingRoom.StarvedUsers,
+		},
+		Clock: jsonClock{
+			Checks:          result.Clock.Checks,
+			Drifted:         result.Clock.Drifted,
+			MaxDriftMinutes: result.Clock.MaxDrift.Minutes(),
+		},
+		Connections: jsonConnections{
+			Sessions: result.Connections.Sessions,
+			Opened:   result.Connections.Opened,
+			PeakOpen: result.Connections.PeakOpen,
+		},
+	}
+	if result.LoadMode == bench.LoadModeOpen {
+		doc.Arrivals = &jsonArrivals{
+			Arrivals:        result.Arrivals.Arrivals,
+			Started:         result.Arrivals.Started,
+			Abandoned:       result.Arrivals.Abandoned,
+			PeakOutstanding: result.Arrivals.PeakOutstanding,
+			StartDelay:      newJSONLatency(result.Arrivals.StartDelay),
+		}
+	}
+	for _, t := range result.ScoreBreakdown {
+		doc.ScoreBreakdown = append(doc.ScoreBreakdown, jsonScoreTerm{Name: t.Name, Points: t.Points, Detail: t.Detail})
+	}
+	for _, e := range result.CriticalErrors {
+		doc.CriticalErrors = append(doc.CriticalErrors, jsonCriticalError{Category: e.Category, Message: e.Message, At: e.At})
+	}
+	for _, v := range result.Violations {
+		rule := v.Rule()
+		jv := jsonViolation{
+			Kind:    v.Kind,
+			Message: v.Message,
+			Fail:    rule.Fail,
+			Penalty: rule.Penalty,
+			Details: v.Details,
+		}
+		doc.Violations = append(doc.Violations, jv)
+		if v.Kind == bench.ViolationDoubleBooking {
+			doc.DoubleBookings = append(doc.DoubleBookings, jv)
+		}
+	}
+
+	for _, e := range result.Endpoints {
+		je := jsonEndpoint{
+			Method:           e.Method,
+			Route:            e.Route,
+			Requests:         e.Requests(),
+			Non2xx:           e.Non2xx,
+			Timeouts:         e.Timeouts,
+			ConnectionErrors: e.ConnectionErrors,
+			jsonLatency:      newJSONLatency(e.LatencyStats),
+		}
+		for _, status := range e.ByStatus {
+			je.ByStatus = append(je.ByStatus, jsonStatus{
+				StatusCode:  status.StatusCode,
+				Count:       status.Count,
+				jsonLatency: newJSONLatency(status.LatencyStats),
+			})
+		}
+		doc.Endpoints = append(doc.Endpoints, je)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	TestCases  []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnitResult writes the result of the benchmark as JUnit XML.
+// Each kind of validation is reported as a test case.
+func writeJUnitResult(w io.Writer, result *bench.Result) error {
+	suite := junitTestSuite{
+		Name:      "ishocon3-benchmark",
+		Time:      fmt.Sprintf("%.3f", result.Duration().Seconds()),
+		Timestamp: result.StartedAt.Format(time.RFC3339),
+		Properties: []junitProperty{
+			{Name: "score", Value: strconv.FormatInt(result.Score, 10)},
+			{Name: "scorer", Value: result.Scorer},
+			{Name: "total_sales", Value: strconv.FormatInt(result.TotalSales, 10)},
+			{Name: "total_purchased", Value: strconv.FormatInt(result.TotalPurchased, 10)},
+			{Name: "total_refunds", Value: strconv.FormatInt(result.TotalRefunds, 10)},
+			{Name: "total_tickets", Value: strconv.FormatInt(result.TotalTickets, 10)},
+			{Name: "seed", Value: strconv.FormatInt(result.Seed, 10)},
+			{Name: "app_language", Value: result.AppLanguage},
+		},
+	}
+
+	criticalErrorCase := junitTestCase{Name: "critical_error", ClassName: "benchmark"}
+	if result.CriticalError != "" {
+		text := result.CriticalError
+		if len(result.CriticalErrors) > 0 {
+			var messages []string
+			for _, e := range result.CriticalErrors {
+				messages = append(messages, fmt.Sprintf("[%s] %s", e.Category, e.Message))
+			}
+			text = strings.Join(messages, "\n")
+		}
+		criticalErrorCase.Failure = &junitFailure{
+			Message: result.CriticalError,
+			Type:    "fail",
+			Text:    text,
+		}
+	}
+	suite.TestCases = append(suite.TestCases, criticalErrorCase)
+
+	for _, kind := range bench.ViolationKinds() {
+		testCase := junitTestCase{Name: string(kind), ClassName: "validation"}
+		var messages []string
+		var rule bench.ViolationRule
+		for _, v := range result.Violations {
+			if v.Kind == kind {
+				messages = append(messages, v.Message)
+				rule = v.Rule()
+			}
+		}
+		if len(messages) > 0 {
+			failureType := "fail"
+			if !rule.Fail {
+				failureType = "penalty"
+				if rule.Penalty == 0 {
+					failureType = "flag"
+				}
+			}
+			testCase.Failure = &junitFailure{
+				Message: fmt.Sprintf("%d violation(s)", len(messages)),
+				Type:    failureType,
+				Text:    strings.Join(messages, "\n"),
+			}
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+
+	suite.Tests = len(suite.TestCases)
+	for _, testCase := range suite.TestCases {
+		if testCase.Failure != nil {
+			suite.Failures++
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeResult writes the result of the benchmark in the given format.
+func writeResult(w io.Writer, format string, result *bench.Result) error {
+	switch format {
+	case "text":
+		printResult(w, result)
+		return nil
+	case "json":
+		return writeJSONResult(w, result)
+	case "junit":
+		return writeJUnitResult(w, result)
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+}
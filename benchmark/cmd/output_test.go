@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/showwin/ISHOCON3/benchmark/bench"
+)
+
+func sampleResult() *bench.Result {
+	return &bench.Result{
+		Score:  0,
+		Scorer: bench.ScorerSales,
+		ScoreBreakdown: []bench.ScoreTerm{
+			{Name: "sales", Points: 120, Detail: "12000 yen of entered tickets / 100"},
+			{Name: "failed", Points: -120, Detail: "double_booking fails the run"},
+		},
+		TotalSales:    12000,
+		CriticalError: "Double booking detected: Schedule E5001-1, Seat 1-A, Section AB",
+		CriticalErrors: []bench.CriticalError{
+			{Category: bench.ErrorCategoryDoubleBooking, Message: "Double booking detected: Schedule E5001-1, Seat 1-A, Section AB"},
+			{Category: bench.ErrorCategoryRefund, Message: "refund failed"},
+		},
+		Violations: []bench.Violation{
+			{
+				Kind:    bench.ViolationDoubleBooking,
+				Message: "Double booking detected: Schedule E5001-1, Seat 1-A, Section AB",
+				Details: map[string]string{"schedule_id": "E5001-1", "seat": "1-A", "section": "AB"},
+			},
+			{Kind: bench.ViolationPhantomTicket, Message: "phantom"},
+		},
+		WaitingRoom: bench.WaitingRoomStats{Admissions: 3, MaxActiveUsers: 5, PeakActiveUsers: 4},
+		Connections: bench.ConnectionStats{Sessions: 10, Opened: 12, PeakOpen: 8},
+		LoadMode:    bench.LoadModeOpen,
+		Clock:       bench.ClockStats{Checks: 4, Drifted: 1, MaxDrift: 30 * time.Minute},
+		Arrivals:    bench.ArrivalStats{Arrivals: 20, Started: 15, Abandoned: 5},
+	}
+}
+
+func TestWriteJSONResult(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJSONResult(&buf, sampleResult()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var doc jsonResult
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to parse JSON output: %v", err)
+	}
+	if doc.TotalSales != 12000 {
+		t.Errorf("Expected total_sales 12000, got %d", doc.TotalSales)
+	}
+	if len(doc.Violations) != 2 {
+		t.Errorf("Expected 2 violations, got %d", len(doc.Violations))
+	}
+	if len(doc.DoubleBookings) != 1 || doc.DoubleBookings[0].Details["seat"] != "1-A" {
+		t.Errorf("Expected the double booking details, got %+v", doc.DoubleBookings)
+	}
+	if len(doc.CriticalErrors) != 2 || doc.CriticalErrors[1].Category != bench.ErrorCategoryRefund {
+		t.Errorf("Expected the critical errors, got %+v", doc.CriticalErrors)
+	}
+	if doc.WaitingRoom.Admissions != 3 || doc.WaitingRoom.PeakActiveUsers != 4 {
+		t.Errorf("Expected the waiting room stats, got %+v", doc.WaitingRoom)
+	}
+	if doc.LoadMode != bench.LoadModeOpen || doc.Arrivals == nil || doc.Arrivals.Abandoned != 5 {
+		t.Errorf("Expected the arrival stats, got %s %+v", doc.LoadMode, doc.Arrivals)
+	}
+	if doc.Clock.Checks != 4 || doc.Clock.MaxDriftMinutes != 30 {
+		t.Errorf("Expected the clock stats, got %+v", doc.Clock)
+	}
+	if doc.Connections.Sessions != 10 || doc.Connections.Opened != 12 {
+		t.Errorf("Expected the connection stats, got %+v", doc.Connections)
+	}
+	if doc.Scorer != bench.ScorerSales || len(doc.ScoreBreakdown) != 2 || doc.ScoreBreakdown[1].Points != -120 {
+		t.Errorf("Expected the score breakdown, got %s %+v", doc.Scorer, doc.ScoreBreakdown)
+	}
+}
+
+func TestWriteJUnitResult(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJUnitResult(&buf, sampleResult()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var doc junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to parse JUnit output: %v", err)
+	}
+	suite := doc.Suites[0]
+	// critical_error + one test case per violation kind
+	if suite.Tests != len(bench.ViolationKinds())+1 {
+		t.Errorf("Expected %d test cases, got %d", len(bench.ViolationKinds())+1, suite.Tests)
+	}
+	// critical_error, double_booking and phantom_ticket
+	if suite.Failures != 3 {
+		t.Errorf("Expected 3 failures, got %d", suite.Failures)
+	}
+}
@@ -1,32 +1,114 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
 	"github.com/spf13/cobra"
 
 	"github.com/showwin/ISHOCON3/benchmark/bench"
+	"github.com/showwin/ISHOCON3/benchmark/bench/logger"
 )
 
 var (
-	targetURL string
-	logLevel  string
+	targetURL    string
+	logLevel     string
+	outputFormat string
+	profilePath  string
+	seed         int64
+	metricsAddr  string
+	tui          bool
+	logFile      string
+	uniqueUsers  bool
+	loadMode     string
+	scorer       string
+	spoolDir     string
 
 	rootCmd = &cobra.Command{
 		Use:   "bench",
 		Short: "A benchmark tool for ISHOCON3",
-		Run: func(cmd *cobra.Command, args []string) {
-			bench.Run(targetURL, logLevel)
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch outputFormat {
+			case "text", "json", "junit":
+			default:
+				return fmt.Errorf("unknown output format: %s (must be one of text, json, junit)", outputFormat)
+			}
+			if tui && outputFormat != "text" {
+				return fmt.Errorf("--tui can only be used with the text output format")
+			}
+
+			mode, err := bench.ParseLoadMode(loadMode)
+			if err != nil {
+				return err
+			}
+
+			var profile *bench.Profile
+			if profilePath != "" {
+				profile, err = bench.LoadProfile(profilePath)
+				if err != nil {
+					return err
+				}
+			}
+
+			config := bench.Config{
+				TargetURL:   targetURL,
+				LogLevel:    logLevel,
+				Profile:     profile,
+				Seed:        seed,
+				MetricsAddr: metricsAddr,
+				UniqueUsers: uniqueUsers,
+				LoadMode:    mode,
+				Scorer:      scorer,
+			}
+
+			if tui {
+				// Logs would break the dashboard, so write them to a file
+				f, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+				if err != nil {
+					return fmt.Errorf("failed to open log file: %w", err)
+				}
+				defer f.Close()
+				logger.SetOutput(f)
+				log.SetOutput(f)
+				config.OnProgress = newDashboard(os.Stdout, logFile).render
+			}
+
+			result, err := bench.Run(context.Background(), config)
+			if err != nil {
+				return fmt.Errorf("failed to start benchmark: %w", err)
+			}
+
+			// Always output final results regardless of log level.
+			// They are written before the score is sent, since the retries may take a while.
+			err = writeResult(os.Stdout, outputFormat, result)
+			postScore(result, spoolDir)
+			return err
 		},
+		SilenceUsage: true,
 	}
 )
 
 // Execute executes the root command.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		panic(err)
+		os.Exit(1)
 	}
 }
 
 func init() {
 	rootCmd.Flags().StringVar(&targetURL, "target", "http://127.0.0.1:8080", "target URL for benchmark")
 	rootCmd.Flags().StringVar(&logLevel, "log-level", "info", "log level (debug, info, warn, error)")
+	rootCmd.Flags().StringVarP(&outputFormat, "output", "o", "text", "result output format (text, json, junit)")
+	rootCmd.Flags().Int64Var(&seed, "seed", 0, "seed of the random user decisions. A random seed is used if 0")
+	rootCmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "address to serve Prometheus metrics on during the benchmark (e.g., :9090). Disabled if empty")
+	rootCmd.Flags().BoolVar(&uniqueUsers, "unique-users", false, "never reuse a user across sessions")
+	rootCmd.Flags().BoolVar(&tui, "tui", false, "show a live dashboard instead of the logs")
+	rootCmd.Flags().StringVar(&logFile, "log-file", "bench.log", "file to write the logs to when --tui is enabled")
+	rootCmd.Flags().StringVar(&loadMode, "load-mode", "closed", "how users are started: closed (fixed workers per phase) or open (arrival rate per phase)")
+	rootCmd.Flags().StringVar(&scorer, "scorer", "", "how the run is scored ("+strings.Join(bench.ScorerNames(), ", ")+"). The scorer of the profile is used if empty")
+	rootCmd.PersistentFlags().StringVar(&spoolDir, "spool-dir", "score_spool", "directory to keep the scores the scoreboard could not receive until they are sent by the submit command")
+	rootCmd.Flags().StringVar(&profilePath, "profile", "", "load profile file (YAML or JSON). The embedded default profile is used if empty")
 }
@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/showwin/ISHOCON3/benchmark/bench"
+)
+
+const (
+	// Timeout of each request to the scoreboard
+	scoreboardTimeout = 10 * time.Second
+	// Number of requests sent for a score before it is spooled
+	scoreboardAttempts = 5
+	// Wait before the first retry, doubled for each of the next ones
+	scoreboardBackoff = time.Second
+)
+
+var jst = time.FixedZone("Asia/Tokyo", 9*60*60)
+
+// scoreSubmission is the score of a run put to the scoreboard.
+// It is written to the spool directory as it is when the scoreboard is unreachable.
+type scoreSubmission struct {
+	Team  string `json:"team"`
+	Score int64  `json:"score"`
+	// Timestamp is when the run finished, so a late submission is still ranked at the time of the run.
+	// The scoreboard stores the entries in DynamoDB keyed by team and timestamp, so a retry of the same run
+	// overwrites its entry instead of adding another one.
+	Timestamp string `json:"timestamp"`
+	Language  string `json:"language"`
+}
+
+func newScoreSubmission(result *bench.Result, team string) scoreSubmission {
+	return scoreSubmission{
+		Team:      team,
+		Score:     result.Score,
+		Timestamp: result.FinishedAt.In(jst).Format(time.RFC3339),
+		Language:  result.AppLanguage,
+	}
+}
+
+// spoolName returns the name of the spool file of sub, unique per team and timestamp like the scoreboard entries.
+func (sub scoreSubmission) spoolName() string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '+' {
+			return r
+		}
+		return '_'
+	}, sub.Team+"_"+sub.Timestamp)
+	return name + ".json"
+}
+
+// rejectedError is a response of the scoreboard which will not change on retry.
+type rejectedError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("scoreboard rejected the score with %d status: %s", e.StatusCode, e.Body)
+}
+
+// scoreboardClient puts scores to the scoreboard, retrying requests which failed or got 429 or 5xx.
+type scoreboardClient struct {
+	apiURL   string
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+}
+
+func newScoreboardClient(apiURL string) *scoreboardClient {
+	return &scoreboardClient{
+		apiURL:   apiURL,
+		client:   &http.Client{Timeout: scoreboardTimeout},
+		attempts: scoreboardAttempts,
+		backoff:  scoreboardBackoff,
+	}
+}
+
+// submit puts sub to the scoreboard. It returns a *rejectedError if the scoreboard refused it,
+// and the last error once all attempts failed.
+func (c *scoreboardClient) submit(ctx context.Context, sub scoreSubmission) error {
+	body, err := json.Marshal(sub)
+	if err != nil {
+		return fmt.Errorf("failed to encode score: %w", err)
+	}
+
+	backoff := c.backoff
+	for attempt := 1; ; attempt++ {
+		err = c.put(ctx, body)
+		var rejected *rejectedError
+		if err == nil || errors.As(err, &rejected) || attempt >= c.attempts {
+			return err
+		}
+		slog.Warn("Failed to send score, retrying", "attempt", attempt, "error", err.Error())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *scoreboardClient) put(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.apiURL+"teams", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("scoreboard returned %d status: %s", resp.StatusCode, respBody)
+	default:
+		return &rejectedError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+}
+
+// postScore sends the score of the run to the scoreboard if it is configured.
+// The score is spooled to spoolDir if the scoreboard is unreachable, to be sent later by `bench submit`.
+func postScore(result *bench.Result, spoolDir string) {
+	apiURL := os.Getenv("BENCH_SCOREBOARD_APIGW_URL")
+	teamName := os.Getenv("BENCH_TEAM_NAME")
+	if apiURL == "" && teamName == "" {
+		return
+	}
+
+	sub := newScoreSubmission(result, teamName)
+	err := newScoreboardClient(apiURL).submit(context.Background(), sub)
+	if err == nil {
+		slog.Info("Score sent to scoreboard")
+		return
+	}
+	var rejected *rejectedError
+	if errors.As(err, &rejected) {
+		slog.Error("Failed to send score", "error", err.Error())
+		return
+	}
+
+	path, spoolErr := spoolScore(spoolDir, sub)
+	if spoolErr != nil {
+		slog.Error("Failed to send score", "error", err.Error())
+		slog.Error("Failed to spool score", "error", spoolErr.Error())
+		return
+	}
+	slog.Error("Failed to send score. Run `bench submit` to send it later.", "error", err.Error(), "spool", path)
+}
+
+// spoolScore writes sub to dir and returns the path of the file.
+func spoolScore(dir string, sub scoreSubmission) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	buf, err := json.MarshalIndent(sub, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	// Write to a temporary file first, so `bench submit` never reads a partial score
+	path := filepath.Join(dir, sub.spoolName())
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// flushSpool sends the spooled scores in dir in the order they were written, and removes the sent ones.
+// A score the scoreboard rejects is renamed to *.rejected so it is kept but not sent again.
+// It returns the number of scores sent and the number left in the spool.
+func flushSpool(ctx context.Context, client *scoreboardClient, dir string) (int, int, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return 0, 0, err
+	}
+	modTimes := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			modTimes[path] = info.ModTime()
+		}
+	}
+	sort.SliceStable(paths, func(i, j int) bool { return modTimes[paths[i]].Before(modTimes[paths[j]]) })
+
+	var sent, left int
+	for _, path := range paths {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return sent, len(paths) - sent, err
+		}
+		var sub scoreSubmission
+		if err := json.Unmarshal(buf, &sub); err != nil {
+			slog.Error("Failed to read spooled score", "path", path, "error", err.Error())
+			left++
+			continue
+		}
+
+		err = client.submit(ctx, sub)
+		var rejected *rejectedError
+		switch {
+		case err == nil:
+			if err := os.Remove(path); err != nil {
+				return sent, len(paths) - sent, err
+			}
+			slog.Info("Score sent to scoreboard", "team", sub.Team, "score", sub.Score, "timestamp", sub.Timestamp)
+			sent++
+		case errors.As(err, &rejected):
+			slog.Error("Scoreboard rejected spooled score", "path", path, "error", err.Error())
+			if err := os.Rename(path, strings.TrimSuffix(path, ".json")+".rejected"); err != nil {
+				return sent, len(paths) - sent, err
+			}
+			left++
+		default:
+			slog.Error("Failed to send spooled score", "path", path, "error", err.Error())
+			left++
+		}
+	}
+	return sent, left, nil
+}
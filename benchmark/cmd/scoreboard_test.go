@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/showwin/ISHOCON3/benchmark/bench"
+)
+
+// scoreboardStub answers with the given status codes in order, then with 200.
+type scoreboardStub struct {
+	mu       sync.Mutex
+	statuses []int
+	attempts int
+	scores   []scoreSubmission
+}
+
+func (s *scoreboardStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	if status == http.StatusOK {
+		var sub scoreSubmission
+		if err := json.NewDecoder(r.Body).Decode(&sub); err == nil {
+			s.scores = append(s.scores, sub)
+		}
+	}
+	w.WriteHeader(status)
+}
+
+func newTestScoreboardClient(url string) *scoreboardClient {
+	client := newScoreboardClient(url + "/")
+	client.backoff = time.Millisecond
+	return client
+}
+
+func TestScoreboardClientRetries(t *testing.T) {
+	stub := &scoreboardStub{statuses: []int{http.StatusInternalServerError, http.StatusTooManyRequests}}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	sub := newScoreSubmission(&bench.Result{Score: 1234, FinishedAt: time.Now()}, "team1")
+	if err := newTestScoreboardClient(server.URL).submit(context.Background(), sub); err != nil {
+		t.Fatalf("Expected the score to be sent, got %v", err)
+	}
+	if stub.attempts != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", stub.attempts)
+	}
+	if len(stub.scores) != 1 || stub.scores[0].Score != 1234 || stub.scores[0].Team != "team1" || stub.scores[0].Timestamp != sub.Timestamp {
+		t.Errorf("Unexpected scores received: %+v", stub.scores)
+	}
+}
+
+func TestScoreboardClientStopsOnRejection(t *testing.T) {
+	stub := &scoreboardStub{statuses: []int{http.StatusBadRequest}}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	err := newTestScoreboardClient(server.URL).submit(context.Background(), scoreSubmission{Team: "team1"})
+	var rejected *rejectedError
+	if !errors.As(err, &rejected) || rejected.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected a rejection, got %v", err)
+	}
+	if stub.attempts != 1 {
+		t.Errorf("Expected no retry, got %d attempts", stub.attempts)
+	}
+}
+
+func TestFlushSpool(t *testing.T) {
+	dir := t.TempDir()
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	sub := newScoreSubmission(&bench.Result{Score: 99, FinishedAt: time.Now()}, "team1")
+	client := newTestScoreboardClient(unreachable.URL)
+	client.attempts = 2
+	if err := client.submit(context.Background(), sub); err == nil {
+		t.Fatal("Expected the unreachable scoreboard to fail")
+	}
+	if _, err := spoolScore(dir, sub); err != nil {
+		t.Fatalf("Expected the score to be spooled, got %v", err)
+	}
+
+	// The scoreboard is still unreachable, so the score is kept
+	sent, left, err := flushSpool(context.Background(), client, dir)
+	if err != nil || sent != 0 || left != 1 {
+		t.Fatalf("Expected the score to be kept, got sent %d, left %d, error %v", sent, left, err)
+	}
+
+	stub := &scoreboardStub{}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	sent, left, err = flushSpool(context.Background(), newTestScoreboardClient(server.URL), dir)
+	if err != nil || sent != 1 || left != 0 {
+		t.Fatalf("Expected the score to be sent, got sent %d, left %d, error %v", sent, left, err)
+	}
+	if stub.attempts != 1 || len(stub.scores) != 1 || stub.scores[0] != sub {
+		t.Errorf("Expected the spooled score, got %d attempts, %+v", stub.attempts, stub.scores)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Errorf("Expected the spool to be empty, got %v", files)
+	}
+}
+
+func TestFlushSpoolKeepsRejected(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := spoolScore(dir, scoreSubmission{Team: "team/1", Timestamp: "2024-05-01T12:34:56+09:00"}); err != nil {
+		t.Fatalf("Expected the score to be spooled, got %v", err)
+	}
+	server := httptest.NewServer(&scoreboardStub{statuses: []int{http.StatusBadRequest}})
+	defer server.Close()
+
+	sent, left, err := flushSpool(context.Background(), newTestScoreboardClient(server.URL), dir)
+	if err != nil || sent != 0 || left != 1 {
+		t.Fatalf("Expected the score to be kept, got sent %d, left %d, error %v", sent, left, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "team_1_2024-05-01T12_34_56+09_00.rejected")); err != nil {
+		t.Errorf("Expected the rejected score to be kept aside, got %v", err)
+	}
+}
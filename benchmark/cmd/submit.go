@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var submitCmd = &cobra.Command{
+	Use:   "submit",
+	Short: "Send the scores spooled while the scoreboard was unreachable",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		apiURL := os.Getenv("BENCH_SCOREBOARD_APIGW_URL")
+		if apiURL == "" {
+			return fmt.Errorf("BENCH_SCOREBOARD_APIGW_URL is not set")
+		}
+
+		sent, left, err := flushSpool(context.Background(), newScoreboardClient(apiURL), spoolDir)
+		if err != nil {
+			return fmt.Errorf("failed to flush %s: %w", spoolDir, err)
+		}
+		slog.Info("Spooled scores submitted", "sent", sent, "left", left)
+		if left > 0 {
+			return fmt.Errorf("%d score(s) could not be sent and are kept in %s", left, spoolDir)
+		}
+		return nil
+	},
+	SilenceUsage: true,
+}
+
+func init() {
+	rootCmd.AddCommand(submitCmd)
+}
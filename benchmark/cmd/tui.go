@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/showwin/ISHOCON3/benchmark/bench"
+)
+
+const (
+	clearScreen     = "\033[H\033[2J"
+	progressBarSize = 30
+)
+
+// dashboard redraws the progress of the benchmark on a terminal.
+type dashboard struct {
+	mu      sync.Mutex
+	w       io.Writer
+	logFile string
+}
+
+func newDashboard(w io.Writer, logFile string) *dashboard {
+	return &dashboard{w: w, logFile: logFile}
+}
+
+// render draws a frame. It is safe to call concurrently.
+func (d *dashboard) render(p bench.Progress) {
+	var buf bytes.Buffer
+	buf.WriteString(clearScreen)
+	writeDashboard(&buf, p, d.logFile)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.w.Write(buf.Bytes())
+}
+
+func writeDashboard(w io.Writer, p bench.Progress, logFile string) {
+	fmt.Fprintf(w, "ISHOCON3 Benchmark  [%s]\n\n", p.Stage)
+	fmt.Fprintf(w, "  Elapsed:          %s %s / %s\n",
+		progressBar(p.Elapsed.Seconds(), p.Duration.Seconds()), formatElapsed(p.Elapsed), formatElapsed(p.Duration))
+	fmt.Fprintf(w, "  Application Time: %s\n", p.ApplicationTime)
+	fmt.Fprintf(w, "  Score Estimate:   %d\n\n", p.ScoreEstimate)
+
+	fmt.Fprintf(w, "  Ticket Phase:     %s %d/%d  %s\n", progressBar(float64(p.TicketPhase), float64(p.TicketPhaseCount)),
+		p.TicketPhase, p.TicketPhaseCount, formatNextThreshold(p.TotalTickets, p.NextTicketThreshold, "tickets"))
+	fmt.Fprintf(w, "  Sales Phase:      %s %d/%d  %s\n\n", progressBar(float64(p.SalesPhase), float64(p.SalesPhaseCount)),
+		p.SalesPhase, p.SalesPhaseCount, formatNextThreshold(p.TotalSales, p.NextSalesThreshold, "yen"))
+
+	fmt.Fprintf(w, "  Active Buyers:    %d\n", p.ActiveBuyers)
+	fmt.Fprintf(w, "  Request Rate:     %.1f req/s\n", p.RequestRate)
+	errorRatio := 0.0
+	if p.RequestRate > 0 {
+		errorRatio = p.ErrorRate / p.RequestRate * 100
+	}
+	fmt.Fprintf(w, "  Error Rate:       %.1f err/s (%.1f%%)\n\n", p.ErrorRate, errorRatio)
+
+	fmt.Fprintln(w, "  Recent Warnings:")
+	if len(p.RecentWarnings) == 0 {
+		fmt.Fprintln(w, "    (none)")
+	}
+	for _, warning := range p.RecentWarnings {
+		fmt.Fprintf(w, "    - %s\n", warning)
+	}
+	fmt.Fprintf(w, "\n  Logs are written to %s\n", logFile)
+}
+
+func progressBar(current, total float64) string {
+	filled := progressBarSize
+	if total > 0 && current < total {
+		filled = int(float64(progressBarSize) * max(current, 0) / total)
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", progressBarSize-filled) + "]"
+}
+
+func formatElapsed(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%02d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+func formatNextThreshold(current, next int64, unit string) string {
+	if next == 0 {
+		return fmt.Sprintf("(%d %s, all phases reached)", current, unit)
+	}
+	return fmt.Sprintf("(%d / %d %s)", current, next, unit)
+}
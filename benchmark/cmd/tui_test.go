@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/showwin/ISHOCON3/benchmark/bench"
+)
+
+func TestWriteDashboard(t *testing.T) {
+	var buf bytes.Buffer
+	writeDashboard(&buf, bench.Progress{
+		Stage:               bench.StageLoad,
+		ApplicationTime:     "05:20",
+		Elapsed:             32 * time.Second,
+		Duration:            60 * time.Second,
+		ScoreEstimate:       1234,
+		TotalTickets:        120,
+		TicketPhase:         3,
+		TicketPhaseCount:    5,
+		NextTicketThreshold: 200,
+		SalesPhase:          7,
+		SalesPhaseCount:     7,
+		TotalSales:          1500000,
+		ActiveBuyers:        85,
+		RequestRate:         200,
+		ErrorRate:           2,
+		RecentWarnings:      []string{"Failed to post /api/reserve (user1)"},
+	}, "bench.log")
+
+	out := buf.String()
+	for _, want := range []string{
+		"[load]",
+		"00:32 / 01:00",
+		"Application Time: 05:20",
+		"Score Estimate:   1234",
+		"3/5  (120 / 200 tickets)",
+		"7/7  (1500000 yen, all phases reached)",
+		"2.0 err/s (1.0%)",
+		"- Failed to post /api/reserve (user1)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dashboard does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	if got := progressBar(1, 2); got != "["+strings.Repeat("#", 15)+strings.Repeat(".", 15)+"]" {
+		t.Errorf("unexpected half progress bar: %s", got)
+	}
+	if got := progressBar(3, 0); got != "["+strings.Repeat("#", 30)+"]" {
+		t.Errorf("unexpected full progress bar: %s", got)
+	}
+}
@@ -517,6 +517,18 @@ def post_entry(
             status="train_departed",
         )
 
+    # JA: 同じ入場トークンで2回以上入場できないことを確認
+    # EN: Confirm that the same entry token cannot be used more than once
+    with engine.begin() as conn:
+        row = conn.execute(
+            text("SELECT * FROM entries WHERE reservation_id = :reservation_id"),
+            {"reservation_id": reservation.id}
+        ).fetchone()
+    if row is not None:
+        return PostEntryResponse(
+            status="already_entered",
+        )
+
     with engine.begin() as conn:
         conn.execute(
             text("""
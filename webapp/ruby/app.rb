@@ -354,6 +354,10 @@ post '/api/entry' do
   # EN: Confirm that the train has not departed yet
   return { status: 'train_departed' }.to_json if reservation.departure_at < Util.application_clock
 
+  # JA: 同じ入場トークンで2回以上入場できないことを確認
+  # EN: Confirm that the same entry token cannot be used more than once
+  return { status: 'already_entered' }.to_json if Entry.exists?(reservation_id: reservation.id)
+
   Entry.create!(reservation_id: reservation.id)
 
   { status: 'success' }.to_json